@@ -5,11 +5,13 @@ go 1.23.0
 toolchain go1.23.2
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/CREDOProject/go-osinfo v1.0.0
 	github.com/CREDOProject/go-pip v0.1.1
 	github.com/CREDOProject/go-rdepends v0.3.0
 	github.com/CREDOProject/sharedutils v0.2.0
 	github.com/go-git/go-git/v5 v5.16.2
+	pault.ag/go/debian v0.19.0
 )
 
 require (
@@ -18,10 +20,12 @@ require (
 	github.com/aquasecurity/go-version v0.0.1 // indirect
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/kjk/lzma v0.0.0-20161016003348-3fd93898850d // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
 	github.com/spf13/pflag v1.0.7 // indirect
+	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
 	golang.org/x/xerrors v0.0.0-20240903120638-7835f813f4da // indirect
-	pault.ag/go/debian v0.19.0 // indirect
 	pault.ag/go/topsort v0.1.1 // indirect
 )
 
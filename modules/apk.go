@@ -0,0 +1,423 @@
+package modules
+
+import (
+	"bufio"
+	"credo/cache"
+	"credo/logger"
+	"credo/project"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	goosinfo "github.com/CREDOProject/go-osinfo"
+	"github.com/CREDOProject/sharedutils/types"
+	"github.com/spf13/cobra"
+)
+
+const apkModuleName = "apk"
+
+const apkModuleShort = "Retrieves an Alpine package and its dependencies with apk."
+
+const apkModuleExample = `
+Install an Alpine package:
+	credo apk python3
+
+Install a given version of an Alpine package:
+	credo apk samtools=1.19-r0
+`
+
+// Name of the index of the saved package files, read by apk.
+const apkIndexFile = "APKINDEX.tar.gz"
+
+// Registers the apkModule.
+func init() {
+	osinfo, err := goosinfo.Retrieve()
+	if err != nil {
+		logger.Get().Fatal(err)
+	}
+	if osinfo.Distribution == "alpine" || slices.Contains(osinfo.Like, "alpine") {
+		Register(apkModuleName, func() Module { return &apkModule{} })
+	}
+}
+
+// apkModule is used to manage the apk scope in the credospell configuration.
+type apkModule struct{}
+
+type apkSpell struct {
+	Name string `yaml:"name"`
+	// Version is the version and the release of the package, such as
+	// 1.19-r0.
+	Version              string     `yaml:"version,omitempty"`
+	Architecture         string     `yaml:"architecture,omitempty"`
+	Dependencies         []apkSpell `yaml:"dependencies,omitempty"`
+	ExternalDependencies Config     `yaml:"external_dependencies,omitempty"`
+}
+
+// Function used to check if two apkSpell objects are equal.
+// It takes in an equatable interface as a parameter and returns a boolean
+// value indicating whether the two objects are equal or not.
+// The function first checks if the input parameter t is of type apkSpell.
+//
+// If it is, it proceeds to compare the Name, Version and Architecture of the
+// two objects and all its other Dependencies.
+// The function returns true if the two objects are equal.
+// Otherwise, it returns false.
+func (a apkSpell) equals(t equatable) bool {
+	o, err := types.To[apkSpell](t)
+	if err != nil {
+		return false
+	}
+	equality := o.Name == a.Name && o.Version == a.Version &&
+		o.Architecture == a.Architecture &&
+		len(o.Dependencies) == len(a.Dependencies)
+	if !equality {
+		return false
+	}
+	for i := range o.Dependencies {
+		equality = equality &&
+			o.Dependencies[i].equals(a.Dependencies[i])
+	}
+	return equality
+}
+
+// pinned returns the package selection understood by apk, pinning the
+// recorded version.
+func (a apkSpell) pinned() string {
+	if a.Version == "" {
+		return a.Name
+	}
+	return a.Name + "=" + a.Version
+}
+
+// apkFile returns the name of the package file of the spell, as written by
+// apk fetch.
+func (a apkSpell) apkFile() string {
+	return a.Name + "-" + a.Version + ".apk"
+}
+
+// closure returns the dependencies of the spell followed by the spell itself.
+func (a apkSpell) closure() []apkSpell {
+	return append(slices.Clone(a.Dependencies), a)
+}
+
+// splitApkPackage splits a package written as name-version-release, the
+// release being rN.
+func splitApkPackage(pkg string) (string, string, bool) {
+	release := strings.LastIndex(pkg, "-")
+	if release <= 0 || !strings.HasPrefix(pkg[release+1:], "r") {
+		return "", "", false
+	}
+	version := strings.LastIndex(pkg[:release], "-")
+	if version <= 0 {
+		return "", "", false
+	}
+	return pkg[:version], pkg[version+1:], true
+}
+
+// parseApkFetch parses the packages which apk fetch would download, sorted
+// by name. Their architecture is not part of the output.
+func parseApkFetch(output []byte) []apkSpell {
+	packages := []apkSpell{}
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		pkg, found := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "Downloading ")
+		if !found {
+			continue
+		}
+		name, version, ok := splitApkPackage(pkg)
+		if !ok || slices.ContainsFunc(packages, func(p apkSpell) bool {
+			return p.Name == name
+		}) {
+			continue
+		}
+		packages = append(packages, apkSpell{Name: name, Version: version})
+	}
+	slices.SortFunc(packages, func(a, b apkSpell) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return packages
+}
+
+// parseApkList parses the packages listed by apk list, written as
+// name-version-release followed by the architecture, and returns their
+// architectures indexed by package file name.
+func parseApkList(output []byte) map[string]string {
+	architectures := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if name, version, ok := splitApkPackage(fields[0]); ok {
+			architectures[apkSpell{Name: name, Version: version}.apkFile()] = fields[1]
+		}
+	}
+	return architectures
+}
+
+// apkArchitectures sets the architecture of the packages, as found in the
+// indexes of the repositories.
+func apkArchitectures(packages []apkSpell) error {
+	names := []string{}
+	for _, p := range packages {
+		names = append(names, p.Name)
+	}
+	cmd := exec.Command("apk", append([]string{"list", "--available"}, names...)...)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("listing %s: %v", strings.Join(names, " "), err)
+	}
+	architectures := parseApkList(output)
+	for i, p := range packages {
+		architecture, ok := architectures[p.apkFile()]
+		if !ok {
+			return fmt.Errorf("%s is not available in the apk repositories.",
+				p.pinned())
+		}
+		packages[i].Architecture = architecture
+	}
+	return nil
+}
+
+// BulkSave implements Module.
+func (m *apkModule) BulkSave(config *Config) error {
+	for _, as := range config.Apk {
+		err := m.Save(as)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CliConfig implements Module.
+func (m *apkModule) CliConfig(config *Config) *cobra.Command {
+	return &cobra.Command{
+		Args:    m.cobraArgs(),
+		Example: apkModuleExample,
+		Run:     m.cobraRun(config),
+		Short:   apkModuleShort,
+		Use:     apkModuleName,
+	}
+}
+
+// Function used to validate the arguments passed to the apk command.
+// If no arguments are passed, it returns an error.
+// Otherwise it returns nil.
+//
+// Intended to be used by cobra.
+func (m *apkModule) cobraArgs() func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return fmt.Errorf("%s module requires at least one argument.",
+				apkModuleName)
+		}
+		return nil
+	}
+}
+
+// Function used to run the module from the command line.
+// It serves as an entry point to the bare run of the apkModule.
+//
+// Intended to be used by cobra.
+func (m *apkModule) cobraRun(config *Config) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, args []string) {
+		spell, err := m.bareRun(apkSpell{
+			Name: args[0],
+		})
+		if err != nil {
+			logger.Get().Fatal(err)
+		}
+		err = m.Commit(config, spell)
+		if err != nil && err != ErrAlreadyPresent {
+			logger.Get().Fatal(err)
+		}
+	}
+}
+
+// bareRun resolves the package and the closure of its dependencies with apk,
+// recording the version chosen for every package.
+func (*apkModule) bareRun(s apkSpell) (apkSpell, error) {
+	if spell := cache.Retrieve(apkModuleName+"bare", s.Name); spell != nil {
+		newSpell, err := types.To[apkSpell](spell)
+		if err == nil {
+			return *newSpell, nil
+		}
+	}
+	cmd := exec.Command("apk", "fetch", "--simulate", "--recursive", s.Name)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return apkSpell{}, fmt.Errorf("resolving %s: %v", s.Name, err)
+	}
+	packages := parseApkFetch(output)
+	if err = apkArchitectures(packages); err != nil {
+		return apkSpell{}, err
+	}
+	name, _, _ := strings.Cut(s.Name, "=")
+	spell := apkSpell{}
+	dependencies := []apkSpell{}
+	for _, p := range packages {
+		if p.Name == name {
+			spell = p
+			continue
+		}
+		dependencies = append(dependencies, p)
+	}
+	if spell.Name == "" {
+		return apkSpell{}, fmt.Errorf("No Alpine package matches %s.", s.Name)
+	}
+	spell.Dependencies = dependencies
+	spell.ExternalDependencies = s.ExternalDependencies
+	_ = cache.Insert(apkModuleName+"bare", s.Name, spell)
+	return spell, nil
+}
+
+// Commit implements Module.
+func (*apkModule) Commit(config *Config, result any) error {
+	newEntry, err := types.To[apkSpell](result)
+	if err != nil {
+		return ErrConverting
+	}
+	if Contains(config.Apk, *newEntry) {
+		return ErrAlreadyPresent
+	}
+	config.Apk = append(config.Apk, *newEntry)
+	return nil
+}
+
+// apkRepository returns the directory of the saved package files in the
+// project, used as the repository when applied, and the directory of the
+// packages of the architecture in it: apk reads the index of a repository
+// from <repository>/<arch>/APKINDEX.tar.gz.
+func apkRepository(projectPath string, arch string) (string, string) {
+	repository := path.Join(projectPath, apkModuleName)
+	return repository, path.Join(repository, arch)
+}
+
+// apkHostArchitecture returns the architecture of the packages of the host.
+func apkHostArchitecture() (string, error) {
+	out, err := exec.Command("apk", "--print-arch").Output()
+	if err != nil {
+		return "", fmt.Errorf("running apk --print-arch: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// apkAddArgs returns the arguments of apk add installing the packages from
+// the repository only.
+func apkAddArgs(repository string, packages []string) []string {
+	return append([]string{"add", "--no-network", "--allow-untrusted",
+		"--repositories-file", os.DevNull, "--repository", repository}, packages...)
+}
+
+// Save implements Module. The package files of the package and its
+// dependencies are downloaded in the recorded versions into the directory
+// of the architecture of the host, whose index is rewritten.
+func (*apkModule) Save(anySpell any) error {
+	spell, err := types.To[apkSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	project, err := project.ProjectPath()
+	if err != nil {
+		return err
+	}
+	arch, err := apkHostArchitecture()
+	if err != nil {
+		return err
+	}
+	_, downloadPath := apkRepository(*project, arch)
+	if err = os.MkdirAll(downloadPath, 0755); err != nil {
+		return err
+	}
+	missing := []string{}
+	for _, s := range spell.closure() {
+		if cache.Retrieve(apkModuleName+"save", s.pinned()) != nil {
+			continue
+		}
+		if _, err := os.Stat(path.Join(downloadPath, s.apkFile())); err == nil {
+			continue
+		}
+		missing = append(missing, s.pinned())
+	}
+	if len(missing) > 0 {
+		args := append([]string{"fetch", "--output", downloadPath}, missing...)
+		out, err := exec.Command("apk", args...).CombinedOutput()
+		logger.Get().Print(string(out))
+		if err != nil {
+			return fmt.Errorf("downloading %s: %v", spell.pinned(), err)
+		}
+	}
+	for _, s := range spell.closure() {
+		_ = cache.Insert(apkModuleName+"save", s.pinned(), true)
+	}
+	return writeApkIndex(downloadPath)
+}
+
+// writeApkIndex writes the unsigned index of the package files of the
+// directory of an architecture, so that its parent can be used as a
+// repository.
+func writeApkIndex(repository string) error {
+	files, err := filepath.Glob(path.Join(repository, "*.apk"))
+	if err != nil {
+		return err
+	}
+	slices.Sort(files)
+	args := append([]string{"index", "--allow-untrusted", "--output",
+		path.Join(repository, apkIndexFile)}, files...)
+	out, err := exec.Command("apk", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("indexing %s: %v %s", repository, err, out)
+	}
+	return nil
+}
+
+// Apply implements Module. The package and its dependencies are installed
+// in their recorded versions from the saved package files, the repositories
+// of the host being ignored.
+func (m *apkModule) Apply(anySpell any) error {
+	spell, err := types.To[apkSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	project, err := project.ProjectPath()
+	if err != nil {
+		return err
+	}
+	arch, err := apkHostArchitecture()
+	if err != nil {
+		return err
+	}
+	repository, downloadPath := apkRepository(*project, arch)
+	packages := []string{}
+	for _, s := range spell.closure() {
+		file := path.Join(downloadPath, s.apkFile())
+		if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s has not been saved, run `credo save` first.",
+				s.pinned())
+		}
+		packages = append(packages, s.pinned())
+	}
+	out, err := exec.Command("apk", apkAddArgs(repository, packages)...).CombinedOutput()
+	logger.Get().Print(string(out))
+	return err
+}
+
+// BulkApply implements Module.
+func (m *apkModule) BulkApply(config *Config) error {
+	for _, as := range config.Apk {
+		err := m.Apply(as)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package modules
+
+import (
+	"path"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func Test_parseApkFetch(t *testing.T) {
+	output := []byte(`Downloading musl-1.2.4-r2
+Downloading samtools-1.19-r0
+Downloading py3-setuptools-70.3.0-r0
+Downloading musl-1.2.4-r2
+fetch: unrelated line
+`)
+	want := []apkSpell{
+		{Name: "musl", Version: "1.2.4-r2"},
+		{Name: "py3-setuptools", Version: "70.3.0-r0"},
+		{Name: "samtools", Version: "1.19-r0"},
+	}
+	if got := parseApkFetch(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseApkFetch() = %v, want %v", got, want)
+	}
+	list := []byte(`musl-1.2.4-r2 x86_64 {musl} (MIT) [installed]
+py3-setuptools-70.3.0-r0 noarch {py3-setuptools} (MIT)
+samtools-1.19-r0 x86_64 {samtools} (MIT)
+samtools-1.18-r1 x86_64 {samtools} (MIT)
+`)
+	wantArchitectures := map[string]string{
+		"musl-1.2.4-r2.apk":            "x86_64",
+		"py3-setuptools-70.3.0-r0.apk": "noarch",
+		"samtools-1.19-r0.apk":         "x86_64",
+		"samtools-1.18-r1.apk":         "x86_64",
+	}
+	if got := parseApkList(list); !reflect.DeepEqual(got, wantArchitectures) {
+		t.Errorf("parseApkList() = %v, want %v", got, wantArchitectures)
+	}
+	spell := want[1]
+	if got := spell.apkFile(); got != "py3-setuptools-70.3.0-r0.apk" {
+		t.Errorf("apkFile() = %v", got)
+	}
+	if got := spell.pinned(); got != "py3-setuptools=70.3.0-r0" {
+		t.Errorf("pinned() = %v", got)
+	}
+}
+
+func Test_apkRepository(t *testing.T) {
+	repository, packages := apkRepository("/project/credoenv", "x86_64")
+	if repository != "/project/credoenv/apk" || packages != "/project/credoenv/apk/x86_64" {
+		t.Errorf("apkRepository() = %v, %v", repository, packages)
+	}
+	// apk reads <repository>/<arch>/APKINDEX.tar.gz, the index written
+	// into the directory of the packages.
+	if path.Join(repository, "x86_64") != packages {
+		t.Errorf("the packages are not in the directory of the architecture")
+	}
+	args := apkAddArgs(repository, []string{"samtools=1.19-r0"})
+	i := slices.Index(args, "--repository")
+	if i < 0 || args[i+1] != repository || args[len(args)-1] != "samtools=1.19-r0" {
+		t.Errorf("apkAddArgs() = %v", args)
+	}
+}
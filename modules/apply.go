@@ -14,10 +14,11 @@ type applyModule struct{}
 
 // CliConfig implements Module.
 func (a applyModule) CliConfig(config *Config) *cobra.Command {
-	return &cobra.Command{
+	command := &cobra.Command{
 		Use:   applyModuleName,
 		Short: "Applies the credospell.yaml configuration in the current directory and installs all the dependencies.",
 		Run: func(cmd *cobra.Command, args []string) {
+			setPipTargetFromFlags(cmd)
 			err := DeepApply(config)
 			if err != nil {
 				logger.Get().Fatal(err)
@@ -25,6 +26,8 @@ func (a applyModule) CliConfig(config *Config) *cobra.Command {
 		},
 		Args: cobra.NoArgs,
 	}
+	addPipTargetFlags(command)
+	return command
 }
 
 // This is a stub method. It should always return nil.
@@ -6,14 +6,17 @@ import (
 	"credo/project"
 	"credo/suggest"
 	"fmt"
+	"maps"
 	"os"
 	"path"
-	"regexp"
+	"slices"
 
 	"github.com/CREDOProject/go-apt-client"
 	goosinfo "github.com/CREDOProject/go-osinfo"
 	"github.com/CREDOProject/sharedutils/types"
 	"github.com/spf13/cobra"
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/dependency"
 )
 
 const aptModuleName = "apt"
@@ -23,9 +26,13 @@ const aptModuleShort = "Retrieves an apt package and its dependencies."
 const aptModuleExample = `
 Install a apt package:
 	credo apt python3
-`
 
-var isAptOptional = regexp.MustCompile(`\<(?P<name>..*)\>`)
+Install a given version of an apt package:
+	credo apt samtools=1.19-1
+
+Install an apt package with the packages it recommends:
+	credo apt samtools --recommends include
+`
 
 // Registers the aptModule.
 func init() {
@@ -53,7 +60,14 @@ func init() {
 type aptModule struct{}
 
 type aptSpell struct {
-	Name                 string     `yaml:"name"`
+	Name         string `yaml:"name"`
+	Version      string `yaml:"version,omitempty"`
+	Architecture string `yaml:"architecture,omitempty"`
+	SHA256       string `yaml:"sha256,omitempty"`
+	// Recommends and Suggests are the policies, include or exclude, used
+	// for the Recommends and Suggests of the packages.
+	Recommends           string     `yaml:"recommends,omitempty"`
+	Suggests             string     `yaml:"suggests,omitempty"`
 	Optional             bool       `yaml:"optional,omitempty"`
 	Dependencies         []aptSpell `yaml:"dependencies,omitempty"`
 	ExternalDependencies Config     `yaml:"external_dependencies,omitempty"`
@@ -64,7 +78,7 @@ type aptSpell struct {
 // value indicating whether the two objects are equal or not.
 // The function first checks if the input parameter t is of type aptSpell.
 //
-// If it is, it proceeds to compare the Name and Optional of the two
+// If it is, it proceeds to compare the Name, Version and policies of the two
 // objects and all its other Dependencies.
 // The function returns true if the two objects are equal.
 // Otherwise, it returns false.
@@ -73,7 +87,9 @@ func (a aptSpell) equals(t equatable) bool {
 	if err != nil {
 		return false
 	}
-	equality := len(o.Dependencies) == len(a.Dependencies)
+	equality := o.Name == a.Name && o.Version == a.Version &&
+		o.Recommends == a.Recommends && o.Suggests == a.Suggests &&
+		len(o.Dependencies) == len(a.Dependencies)
 	if !equality {
 		return false
 	}
@@ -87,15 +103,6 @@ func (a aptSpell) equals(t equatable) bool {
 // BulkSave implements Module.
 func (m *aptModule) BulkSave(config *Config) error {
 	for _, as := range config.Apt {
-		for _, dep := range as.Dependencies {
-			if dep.Optional {
-				continue
-			}
-			err := m.Save(dep)
-			if err != nil {
-				return err
-			}
-		}
 		err := m.Save(as)
 		if err != nil {
 			return err
@@ -106,13 +113,18 @@ func (m *aptModule) BulkSave(config *Config) error {
 
 // CliConfig implements Module.
 func (m *aptModule) CliConfig(config *Config) *cobra.Command {
-	return &cobra.Command{
+	command := &cobra.Command{
 		Args:    m.cobraArgs(),
 		Example: aptModuleExample,
 		Run:     m.cobraRun(config),
 		Short:   aptModuleShort,
 		Use:     aptModuleName,
 	}
+	command.Flags().String("recommends", "",
+		"Whether the recommended packages are included or excluded.")
+	command.Flags().String("suggests", "",
+		"Whether the suggested packages are included or excluded.")
+	return command
 }
 
 // Function used to validate the arguments passed to the apt command.
@@ -137,8 +149,12 @@ func (m *aptModule) cobraArgs() func(*cobra.Command, []string) error {
 func (m *aptModule) cobraRun(config *Config) func(*cobra.Command, []string) {
 	return func(cmd *cobra.Command, args []string) {
 		name := args[0]
+		recommends, _ := cmd.Flags().GetString("recommends")
+		suggests, _ := cmd.Flags().GetString("suggests")
 		spell, err := m.bareRun(aptSpell{
-			Name: name,
+			Name:       name,
+			Recommends: recommends,
+			Suggests:   suggests,
 		})
 		if err != nil {
 			logger.Get().Fatal(err)
@@ -150,49 +166,99 @@ func (m *aptModule) cobraRun(config *Config) func(*cobra.Command, []string) {
 	}
 }
 
+// bareRun resolves the closure of the Depends and Pre-Depends of the
+// package, recording the version chosen for every package, and reports the
+// packages of the closure which conflict with, or break, each other. The
+// Recommends and Suggests are part of the closure when included by the
+// policies of the spell or the project, and are registered as suggestions
+// otherwise.
 func (*aptModule) bareRun(s aptSpell) (aptSpell, error) {
-	if spell := cache.Retrieve(aptModuleName, s.Name); spell != nil {
-		newSpell, err := types.To[aptSpell](s)
+	recommends, err := aptPolicy("recommends", s.Recommends,
+		projectSettings.Apt.Recommends)
+	if err != nil {
+		return aptSpell{}, err
+	}
+	suggests, err := aptPolicy("suggests", s.Suggests, projectSettings.Apt.Suggests)
+	if err != nil {
+		return aptSpell{}, err
+	}
+	key := fmt.Sprintf("%s recommends=%s suggests=%s", s.Name, recommends, suggests)
+	if spell := cache.Retrieve(aptModuleName+"bare", key); spell != nil {
+		newSpell, err := types.To[aptSpell](spell)
 		if err == nil {
 			return *newSpell, nil
 		}
 	}
-	aptPack := &apt.Package{
-		Name: s.Name,
-	}
-	_, err := apt.CheckForUpdates()
+	session, err := getAptSession()
 	if err != nil {
-		return aptSpell{}, fmt.Errorf("While running: %s, failed to check for updates: %w", s.Name, err)
+		return aptSpell{}, err
 	}
-	output, err := apt.InstallDry(aptPack)
-	logger.Get().Print(string(output))
+	if err = session.update(); err != nil {
+		return aptSpell{}, fmt.Errorf("While running: %s, %w", s.Name, err)
+	}
+	relation, err := dependency.Parse(aptPinnedName(s.Name))
+	if err != nil || len(relation.Relations) != 1 {
+		return aptSpell{}, fmt.Errorf("%w %s is not an apt package.",
+			ErrInvalidSpell, s.Name)
+	}
+	resolver := session.resolver()
+	chosen, err := resolver.resolve(relation.Relations[0])
 	if err != nil {
 		return aptSpell{}, err
 	}
-	depList, err := apt.GetDependencies(aptPack)
-	if err != nil {
+	if err = resolver.conflict(); err != nil {
 		return aptSpell{}, err
 	}
-	for _, dependency := range depList {
-		isOptional := isAptOptional.MatchString(dependency)
-		cleanDependency := dependency
-		matches := isAptOptional.FindStringSubmatch(dependency)
-		nameIndex := isAptOptional.SubexpIndex("name")
-		if nameIndex != -1 && isOptional {
-			cleanDependency = matches[nameIndex]
+	fields := map[string]string{"Recommends": recommends, "Suggests": suggests}
+	included := []string{}
+	for _, field := range slices.Sorted(maps.Keys(fields)) {
+		if fields[field] == aptPolicyInclude {
+			included = append(included, field)
+			continue
+		}
+		relations := aptRelations(chosen, field)
+		for _, possibility := range relations.GetAllPossibilities() {
 			suggest.Register(suggest.Suggestion{
 				Module:    aptModuleName,
-				From:      aptPack.Name,
-				Suggested: cleanDependency,
+				From:      chosen.Package,
+				Suggested: possibility.Name,
 			})
 		}
-		s.Dependencies = append(s.Dependencies, aptSpell{
-			Name:     cleanDependency,
-			Optional: isOptional,
-		})
 	}
-	_ = cache.Insert(pipModuleName, s.Name, s)
-	return s, nil
+	optional := resolver.resolveOptional(included)
+	spell := aptSpellFrom(chosen)
+	spell.Recommends, spell.Suggests = recommends, suggests
+	spell.ExternalDependencies = s.ExternalDependencies
+	for _, p := range resolver.closure(chosen.Package) {
+		dep := aptSpellFrom(p)
+		dep.Optional = slices.Contains(optional, p.Package)
+		spell.Dependencies = append(spell.Dependencies, dep)
+	}
+	_ = cache.Insert(aptModuleName+"bare", key, spell)
+	return spell, nil
+}
+
+// closure returns the dependencies of the spell, without the optional ones
+// unless the policies of the spell include them, followed by the spell
+// itself.
+func (a aptSpell) closure() []aptSpell {
+	spells := []aptSpell{}
+	for _, dep := range a.Dependencies {
+		if !dep.Optional || a.includesOptional() {
+			spells = append(spells, dep)
+		}
+	}
+	return append(spells, a)
+}
+
+// aptSpellFrom returns the spell pinning the package of the index.
+func aptSpellFrom(p control.BinaryIndex) aptSpell {
+	return aptSpell{
+		Name:         p.Package,
+		Version:      p.Version.String(),
+		Architecture: p.Architecture.String(),
+		SHA256:       p.SHA256,
+	}
 }
 
 // Commit implements Module.
@@ -208,33 +274,46 @@ func (*aptModule) Commit(config *Config, result any) error {
 	return nil
 }
 
-// Save implements Module.
+// Save implements Module. The package files of the package and its
+// dependencies are downloaded in the recorded versions, into a flat
+// repository.
 func (*aptModule) Save(anySpell any) error {
 	spell, err := types.To[aptSpell](anySpell)
 	if err != nil {
 		return ErrConverting
 	}
-	if cache.Retrieve(aptModuleName, spell.Name) != nil {
-		return nil
-	}
 	project, err := project.ProjectPath()
 	if err != nil {
 		return err
 	}
 	downloadPath := path.Join(*project, aptModuleName)
 	os.MkdirAll(downloadPath, 0755)
-	aptPack := &apt.Package{
-		Name: spell.Name,
-	}
-	out, err := apt.Download(aptPack, downloadPath)
-	logger.Get().Print(string(out))
-	if err == nil {
-		_ = cache.Insert(aptModuleName, spell.Name, true)
+	for _, s := range spell.closure() {
+		if cache.Retrieve(aptModuleName+"save", s.pinned()) != nil {
+			continue
+		}
+		if s.Version != "" {
+			err = s.downloadDeb(downloadPath)
+		} else {
+			// Spells resolved before versions were recorded.
+			aptPack := &apt.Package{
+				Name: s.Name,
+			}
+			var out []byte
+			out, err = apt.Download(aptPack, downloadPath)
+			logger.Get().Print(string(out))
+		}
+		if err != nil {
+			return err
+		}
+		_ = cache.Insert(aptModuleName+"save", s.pinned(), true)
 	}
-	return err
+	return writeAptRepository(downloadPath)
 }
 
-// Apply implements Module.
+// Apply implements Module. The package and its dependencies are installed
+// from the saved package files, in the recorded versions. Without root, they
+// are unpacked into the project instead.
 func (m *aptModule) Apply(anySpell any) error {
 	spell, err := types.To[aptSpell](anySpell)
 	if err != nil {
@@ -244,27 +323,48 @@ func (m *aptModule) Apply(anySpell any) error {
 	if err != nil {
 		return err
 	}
+	mode, err := aptApplyMode()
+	if err != nil {
+		return err
+	}
 	downloadPath := path.Join(*project, aptModuleName)
-	aptPack := &apt.Package{
-		Name: spell.Name,
+	spells := spell.closure()
+	for _, s := range spells {
+		if s.Version != "" {
+			continue
+		}
+		// Spells resolved before versions were recorded.
+		if mode == aptApplyRootless {
+			return fmt.Errorf("%s has no recorded version, run `credo apt %s` again to apply it without root.",
+				s.Name, spell.Name)
+		}
+		return m.applyUnpinned(downloadPath, spells)
+	}
+	if mode == aptApplyRootless {
+		return unpackDebs(downloadPath, spells)
 	}
-	out, err := apt.Install(downloadPath, aptPack)
-	logger.Get().Print(string(out))
-	return err
+	return installDebs(downloadPath, spells)
+}
+
+// applyUnpinned installs the spells one by one, using the saved package
+// files when apt selects their versions.
+func (m *aptModule) applyUnpinned(downloadPath string, spells []aptSpell) error {
+	for _, s := range spells {
+		aptPack := &apt.Package{
+			Name: s.pinned(),
+		}
+		out, err := apt.Install(downloadPath, aptPack)
+		logger.Get().Print(string(out))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // BulkApply implements Module.
 func (m *aptModule) BulkApply(config *Config) error {
 	for _, as := range config.Apt {
-		for _, dep := range as.Dependencies {
-			if dep.Optional {
-				continue
-			}
-			err := m.Apply(dep)
-			if err != nil {
-				return err
-			}
-		}
 		err := m.Apply(as)
 		if err != nil {
 			return err
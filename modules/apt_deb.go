@@ -0,0 +1,98 @@
+package modules
+
+import (
+	"credo/logger"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// aptPinnedName returns the relation selecting the package, written either as
+// an apt relation or as name=version, the syntax of apt-get.
+func aptPinnedName(name string) string {
+	if strings.ContainsAny(name, "()|") {
+		return name
+	}
+	if pkg, version, found := strings.Cut(name, "="); found {
+		return fmt.Sprintf("%s (= %s)", pkg, version)
+	}
+	return name
+}
+
+// pinned returns the package selection understood by apt-get, pinning the
+// recorded version.
+func (a aptSpell) pinned() string {
+	if a.Version == "" {
+		return a.Name
+	}
+	return a.Name + "=" + a.Version
+}
+
+// debFile returns the name of the package file of the spell, as written by
+// apt-get. The colon of an epoch is escaped.
+func (a aptSpell) debFile() string {
+	return fmt.Sprintf("%s_%s_%s.deb", a.Name,
+		strings.ReplaceAll(a.Version, ":", "%3a"), a.Architecture)
+}
+
+// downloadDeb downloads exactly the recorded version of the package into
+// downloadPath and checks its hash. A file already downloaded is kept if its
+// hash matches.
+func (a aptSpell) downloadDeb(downloadPath string) error {
+	file := path.Join(downloadPath, a.debFile())
+	if _, err := os.Stat(file); err == nil && a.verifyDeb(file) == nil {
+		return nil
+	}
+	cmd, err := aptCommand("apt-get", "download", a.pinned())
+	if err != nil {
+		return err
+	}
+	cmd.Dir = downloadPath
+	out, err := cmd.CombinedOutput()
+	logger.Get().Print(string(out))
+	if err != nil {
+		return fmt.Errorf("downloading %s: %v", a.pinned(), err)
+	}
+	return a.verifyDeb(file)
+}
+
+// verifyDeb checks the hash of the package file against the index.
+func (a aptSpell) verifyDeb(file string) error {
+	if a.SHA256 == "" {
+		return nil
+	}
+	hash, err := sha256File(file)
+	if err != nil {
+		return err
+	}
+	if hash != a.SHA256 {
+		return fmt.Errorf("%s has sha256 %s, expected %s.", path.Base(file),
+			hash, a.SHA256)
+	}
+	return nil
+}
+
+// installDebs installs the saved package files of the spells at once, so
+// that apt orders their configuration, without downloading anything. The
+// recorded versions are installed even if they are older than the installed
+// ones.
+func installDebs(downloadPath string, spells []aptSpell) error {
+	args := []string{"install", "-y", "--no-download", "--allow-downgrades"}
+	for _, spell := range spells {
+		file := path.Join(downloadPath, spell.debFile())
+		if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s has not been saved, run `credo save` first.",
+				spell.pinned())
+		}
+		args = append(args, file)
+	}
+	cmd, err := aptCommand("apt-get", args...)
+	if err != nil {
+		return err
+	}
+	out, err := cmd.CombinedOutput()
+	logger.Get().Print(string(out))
+	return err
+}
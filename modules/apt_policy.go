@@ -0,0 +1,69 @@
+package modules
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Policies of the Recommends and Suggests of apt packages.
+const (
+	aptPolicyInclude = "include"
+	aptPolicyExclude = "exclude"
+)
+
+// aptPolicy returns the policy of the spell, or the one of the project when
+// the spell has none. Recommends and Suggests are excluded by default.
+func aptPolicy(field string, spell string, project string) (string, error) {
+	policy := spell
+	if policy == "" {
+		policy = project
+	}
+	switch policy {
+	case "":
+		return aptPolicyExclude, nil
+	case aptPolicyInclude, aptPolicyExclude:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%w apt %s policy %s is neither %s nor %s.",
+		ErrInvalidSpell, field, policy, aptPolicyInclude, aptPolicyExclude)
+}
+
+// includesOptional reports whether the optional dependencies recorded in
+// the spell are part of it. Optional dependencies are recorded only when
+// included, except by spells without policy, which predate the policies.
+func (a aptSpell) includesOptional() bool {
+	return a.Recommends == aptPolicyInclude || a.Suggests == aptPolicyInclude
+}
+
+// resolveOptional adds the packages satisfying the given relationship fields,
+// such as Recommends, of the packages of the closure, until no package is
+// added. Relations which cannot be satisfied, or only by packages conflicting
+// with the closure, are skipped, as apt does. It returns the names of the
+// packages added.
+func (r *aptResolver) resolveOptional(fields []string) []string {
+	required := maps.Clone(r.resolved)
+	for {
+		size := len(r.resolved)
+		for _, name := range slices.Sorted(maps.Keys(r.resolved)) {
+			for _, field := range fields {
+				for _, relation := range aptRelations(r.resolved[name], field).Relations {
+					resolved := maps.Clone(r.resolved)
+					if _, err := r.resolve(relation); err != nil || r.conflict() != nil {
+						r.resolved = resolved
+					}
+				}
+			}
+		}
+		if len(r.resolved) == size {
+			break
+		}
+	}
+	added := []string{}
+	for _, name := range slices.Sorted(maps.Keys(r.resolved)) {
+		if _, ok := required[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	return added
+}
@@ -0,0 +1,186 @@
+package modules
+
+import (
+	"bytes"
+	"compress/gzip"
+	"credo/logger"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/deb"
+)
+
+// Control stanzas of the saved package files, indexed by file name. The name
+// of a package file pins its version, so that a file is read once.
+var aptRepositoryStanzas = map[string]string{}
+
+// aptFileHashes are the hashes of a file listed in a repository index.
+type aptFileHashes struct {
+	size   int64
+	md5    string
+	sha1   string
+	sha256 string
+}
+
+// hashAptFile returns the size and the hashes of the file.
+func hashAptFile(file string) (aptFileHashes, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return aptFileHashes{}, err
+	}
+	defer f.Close()
+	hashes := []hash.Hash{md5.New(), sha1.New(), sha256.New()}
+	size, err := io.Copy(io.MultiWriter(hashes[0], hashes[1], hashes[2]), f)
+	if err != nil {
+		return aptFileHashes{}, err
+	}
+	return aptFileHashes{
+		size:   size,
+		md5:    hex.EncodeToString(hashes[0].Sum(nil)),
+		sha1:   hex.EncodeToString(hashes[1].Sum(nil)),
+		sha256: hex.EncodeToString(hashes[2].Sum(nil)),
+	}, nil
+}
+
+// aptRepositoryStanza returns the stanza of the package file in the index of
+// the repository: its control file, followed by its location and hashes.
+func aptRepositoryStanza(repository string, name string) (string, error) {
+	if stanza, ok := aptRepositoryStanzas[name]; ok {
+		return stanza, nil
+	}
+	file := path.Join(repository, name)
+	archive, closer, err := deb.LoadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("reading the control file of %s: %v", name, err)
+	}
+	paragraph := archive.Control.Paragraph
+	if err = closer(); err != nil {
+		return "", err
+	}
+	hashes, err := hashAptFile(file)
+	if err != nil {
+		return "", err
+	}
+	// Multiline values are parsed with their final newline.
+	for key, value := range paragraph.Values {
+		paragraph.Values[key] = strings.TrimRight(value, "\n")
+	}
+	paragraph.Set("Filename", "./"+name)
+	paragraph.Set("Size", fmt.Sprint(hashes.size))
+	paragraph.Set("MD5sum", hashes.md5)
+	paragraph.Set("SHA1", hashes.sha1)
+	paragraph.Set("SHA256", hashes.sha256)
+	var buffer bytes.Buffer
+	if err = paragraph.WriteTo(&buffer); err != nil {
+		return "", err
+	}
+	stanza := buffer.String()
+	aptRepositoryStanzas[name] = stanza
+	return stanza, nil
+}
+
+// aptRepositoryIndex returns the Packages index of the package files of the
+// repository, sorted by name, and the architectures they target.
+func aptRepositoryIndex(repository string) ([]byte, []string, error) {
+	files, err := filepath.Glob(path.Join(repository, "*.deb"))
+	if err != nil {
+		return nil, nil, err
+	}
+	slices.Sort(files)
+	stanzas := []string{}
+	architectures := []string{}
+	for _, file := range files {
+		stanza, err := aptRepositoryStanza(repository, path.Base(file))
+		if err != nil {
+			return nil, nil, err
+		}
+		stanzas = append(stanzas, stanza)
+		var fields control.BinaryIndex
+		err = control.Unmarshal(&fields, strings.NewReader(stanza))
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing the control file of %s: %v",
+				path.Base(file), err)
+		}
+		architecture := fields.Architecture.String()
+		if architecture != aptArchitectureAll &&
+			!slices.Contains(architectures, architecture) {
+			architectures = append(architectures, architecture)
+		}
+	}
+	slices.Sort(architectures)
+	return []byte(strings.Join(stanzas, "\n")), architectures, nil
+}
+
+// aptRelease returns the Release file of a flat repository listing the
+// index files with their hashes.
+func aptRelease(architectures []string, files map[string][]byte,
+	date time.Time) string {
+	names := slices.Sorted(maps.Keys(files))
+	release := fmt.Sprintf("Origin: CREDO\nLabel: CREDO\nDate: %s\n",
+		date.UTC().Format(time.RFC1123Z))
+	if len(architectures) > 0 {
+		release += fmt.Sprintf("Architectures: %s\n", strings.Join(architectures, " "))
+	}
+	sums := []struct {
+		field string
+		sum   func([]byte) string
+	}{
+		{"MD5Sum", func(b []byte) string { s := md5.Sum(b); return hex.EncodeToString(s[:]) }},
+		{"SHA1", func(b []byte) string { s := sha1.Sum(b); return hex.EncodeToString(s[:]) }},
+		{"SHA256", func(b []byte) string { s := sha256.Sum256(b); return hex.EncodeToString(s[:]) }},
+	}
+	for _, sum := range sums {
+		release += sum.field + ":\n"
+		for _, name := range names {
+			release += fmt.Sprintf(" %s %d %s\n", sum.sum(files[name]),
+				len(files[name]), name)
+		}
+	}
+	return release
+}
+
+// writeAptRepository turns the directory of the saved package files into a
+// flat repository, which apt can use with the following source:
+//
+//	deb [trusted=yes] file:<repository> ./
+func writeAptRepository(repository string) error {
+	packages, architectures, err := aptRepositoryIndex(repository)
+	if err != nil {
+		return err
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err = writer.Write(packages); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		"Packages":    packages,
+		"Packages.gz": compressed.Bytes(),
+	}
+	release := aptRelease(architectures, files, time.Now())
+	files["Release"] = []byte(release)
+	for name, content := range files {
+		if err = os.WriteFile(path.Join(repository, name), content, 0644); err != nil {
+			return err
+		}
+	}
+	logger.Get().Printf("[apt/save]: saved packages available with the source `deb [trusted=yes] file:%s ./`.",
+		repository)
+	return nil
+}
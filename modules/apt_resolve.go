@@ -0,0 +1,455 @@
+package modules
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/dependency"
+	"pault.ag/go/debian/version"
+)
+
+// Architecture of the packages which are not architecture specific.
+const aptArchitectureAll = "all"
+
+// Package priorities, from the most to the least important. They break ties
+// between the providers of a virtual package, as apt does.
+var aptPriorities = []string{"required", "important", "standard", "optional",
+	"extra"}
+
+// Relationship fields forbidding the installation of two packages together.
+var aptConflictFields = []string{"Breaks", "Conflicts"}
+
+// aptPackageInfo is what the package index knows about a package name.
+type aptPackageInfo struct {
+	// Versions available for the architecture, from the newest.
+	versions []control.BinaryIndex
+	// Candidate is the version apt would install, according to the pins and
+	// the priorities of the sources.
+	candidate string
+	// Providers are the packages providing the name, when it is virtual.
+	providers []string
+}
+
+// aptResolver computes the closure of the Depends and Pre-Depends of apt
+// packages, choosing a concrete package and version for every relation.
+type aptResolver struct {
+	architecture string
+	// lookup returns what the index knows about each of the names.
+	lookup   func(names []string) (map[string]*aptPackageInfo, error)
+	packages map[string]*aptPackageInfo
+	resolved map[string]control.BinaryIndex
+}
+
+// aptCacheLookup queries apt-cache about several package names at once, as
+// every invocation loads the whole index. A name unknown to the index has
+// neither versions nor providers.
+func aptCacheLookup(names []string, architecture string) (map[string]*aptPackageInfo, error) {
+	// apt-cache exits with an error when one of the names is unknown, which
+	// is reported by the resolver when the name is required.
+	show, err := aptCacheOutput("show", names)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := parseAptIndex(show, architecture)
+	if err != nil {
+		return nil, fmt.Errorf("reading the apt index: %v", err)
+	}
+	policy, err := aptCacheOutput("policy", names)
+	if err != nil {
+		return nil, err
+	}
+	candidates := parseAptCandidates(policy)
+	infos := map[string]*aptPackageInfo{}
+	virtual := []string{}
+	for _, name := range names {
+		info := &aptPackageInfo{candidate: candidates[name]}
+		for _, v := range versions {
+			if v.Package == name {
+				info.versions = append(info.versions, v)
+			}
+		}
+		if len(info.versions) == 0 {
+			virtual = append(virtual, name)
+		}
+		infos[name] = info
+	}
+	if len(virtual) > 0 {
+		showpkg, err := aptCacheOutput("showpkg", virtual)
+		if err != nil {
+			return nil, err
+		}
+		for name, providers := range parseAptProviders(showpkg) {
+			if info, ok := infos[name]; ok {
+				info.providers = providers
+			}
+		}
+	}
+	return infos, nil
+}
+
+// aptCacheOutput returns the output of an apt-cache command about the names.
+// apt-cache fails when any name is unknown or purely virtual, so that a
+// failing exit status is tolerated when there is an output, or when apt-cache
+// only reports such names.
+func aptCacheOutput(command string, names []string) ([]byte, error) {
+	cmd, err := aptCommand("apt-cache", append([]string{command}, names...)...)
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	// The reports about the names are matched in English.
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if err == nil || (errors.As(err, &exitErr) &&
+		(len(bytes.TrimSpace(output)) > 0 || aptUnknownNamesOnly(stderr.String()))) {
+		return output, nil
+	}
+	return nil, fmt.Errorf("running apt-cache %s: %v\n%s", command, err,
+		strings.TrimSpace(stderr.String()))
+}
+
+// aptUnknownNamesOnly reports whether the error output of apt-cache only
+// reports unknown or purely virtual names.
+func aptUnknownNamesOnly(stderr string) bool {
+	for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "N: ") && line != "E: No packages found" {
+			return false
+		}
+	}
+	return true
+}
+
+// parseAptIndex parses package stanzas, as found in a Packages file or in
+// the output of apt-cache show, and returns those installable on the
+// architecture, from the newest version.
+func parseAptIndex(content []byte, architecture string) ([]control.BinaryIndex, error) {
+	stanzas := []control.BinaryIndex{}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return stanzas, nil
+	}
+	if err := control.Unmarshal(&stanzas, bytes.NewReader(content)); err != nil {
+		return nil, err
+	}
+	versions := []control.BinaryIndex{}
+	for _, stanza := range stanzas {
+		arch := stanza.Architecture.String()
+		if arch != architecture && arch != aptArchitectureAll {
+			continue
+		}
+		// The same version is listed once per source providing it.
+		if slices.ContainsFunc(versions, func(v control.BinaryIndex) bool {
+			return v.Package == stanza.Package &&
+				version.Compare(v.Version, stanza.Version) == 0
+		}) {
+			continue
+		}
+		versions = append(versions, stanza)
+	}
+	slices.SortStableFunc(versions, func(a, b control.BinaryIndex) int {
+		return version.Compare(b.Version, a.Version)
+	})
+	return versions, nil
+}
+
+// parseAptCandidates returns the candidate versions reported by apt-cache
+// policy, indexed by package name.
+func parseAptCandidates(output []byte) map[string]string {
+	candidates := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	name := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, " ") && strings.HasSuffix(line, ":") {
+			name = strings.TrimSuffix(line, ":")
+			continue
+		}
+		candidate, found := strings.CutPrefix(strings.TrimSpace(line), "Candidate:")
+		if found && name != "" {
+			candidate = strings.TrimSpace(candidate)
+			if candidate != "(none)" {
+				candidates[name] = candidate
+			}
+		}
+	}
+	return candidates
+}
+
+// parseAptProviders returns the names listed in the Reverse Provides
+// sections of the output of apt-cache showpkg, indexed by package name.
+func parseAptProviders(output []byte) map[string][]string {
+	providers := map[string][]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	name := ""
+	inSection := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if pkg, found := strings.CutPrefix(line, "Package: "); found {
+			name, inSection = strings.TrimSpace(pkg), false
+			continue
+		}
+		if strings.HasPrefix(line, "Reverse Provides:") {
+			inSection = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if !inSection || len(fields) == 0 {
+			inSection = false
+			continue
+		}
+		provider, _, _ := strings.Cut(fields[0], ":")
+		if !slices.Contains(providers[name], provider) {
+			providers[name] = append(providers[name], provider)
+		}
+	}
+	return providers
+}
+
+// prefetch looks up the names which are not known yet, at once.
+func (r *aptResolver) prefetch(names []string) error {
+	missing := []string{}
+	for _, name := range names {
+		if _, ok := r.packages[name]; !ok && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	infos, err := r.lookup(missing)
+	if err != nil {
+		return err
+	}
+	for _, name := range missing {
+		info, ok := infos[name]
+		if !ok {
+			info = &aptPackageInfo{}
+		}
+		r.packages[name] = info
+	}
+	return nil
+}
+
+// info returns what the index knows about name.
+func (r *aptResolver) info(name string) (*aptPackageInfo, error) {
+	if err := r.prefetch([]string{name}); err != nil {
+		return nil, err
+	}
+	return r.packages[name], nil
+}
+
+// resolve adds the packages satisfying the relation, and their Depends and
+// Pre-Depends, to the closure. It returns the package chosen for the
+// relation.
+func (r *aptResolver) resolve(relation dependency.Relation) (control.BinaryIndex, error) {
+	possibilities := []dependency.Possibility{}
+	for _, possibility := range relation.Possibilities {
+		if !possibility.Substvar {
+			possibilities = append(possibilities, possibility)
+		}
+	}
+	// A relation already satisfied by the closure needs no new package.
+	for _, possibility := range possibilities {
+		if chosen, ok := r.satisfiedBy(possibility); ok {
+			return chosen, nil
+		}
+	}
+	for _, possibility := range possibilities {
+		chosen, ok, err := r.choose(possibility)
+		if err != nil {
+			return control.BinaryIndex{}, err
+		}
+		if !ok {
+			continue
+		}
+		if err = r.add(chosen); err != nil {
+			return control.BinaryIndex{}, err
+		}
+		return chosen, nil
+	}
+	return control.BinaryIndex{}, fmt.Errorf("Unable to satisfy the apt dependency %s.",
+		relation.String())
+}
+
+// add adds the package to the closure, then resolves its dependencies.
+func (r *aptResolver) add(chosen control.BinaryIndex) error {
+	r.resolved[chosen.Package] = chosen
+	depends := chosen.GetPreDepends()
+	depends.Relations = append(depends.Relations, chosen.GetDepends().Relations...)
+	names := []string{}
+	for _, possibility := range depends.GetAllPossibilities() {
+		names = append(names, possibility.Name)
+	}
+	if err := r.prefetch(names); err != nil {
+		return err
+	}
+	for _, relation := range depends.Relations {
+		if _, err := r.resolve(relation); err != nil {
+			return fmt.Errorf("%s: %v", chosen.Package, err)
+		}
+	}
+	return nil
+}
+
+// satisfiedBy returns the package of the closure satisfying the possibility,
+// directly or through its Provides.
+func (r *aptResolver) satisfiedBy(possibility dependency.Possibility) (control.BinaryIndex, bool) {
+	if chosen, ok := r.resolved[possibility.Name]; ok &&
+		aptVersionSatisfies(possibility.Version, chosen.Version) {
+		return chosen, true
+	}
+	for _, name := range slices.Sorted(maps.Keys(r.resolved)) {
+		if aptProvides(r.resolved[name], possibility) {
+			return r.resolved[name], true
+		}
+	}
+	return control.BinaryIndex{}, false
+}
+
+// choose returns the version of a package satisfying the possibility. The
+// candidate version is preferred, then the newest one. A virtual package is
+// satisfied by the most important of its providers.
+func (r *aptResolver) choose(possibility dependency.Possibility) (control.BinaryIndex, bool, error) {
+	info, err := r.info(possibility.Name)
+	if err != nil {
+		return control.BinaryIndex{}, false, err
+	}
+	if len(info.versions) > 0 {
+		if _, conflict := r.resolved[possibility.Name]; conflict {
+			return control.BinaryIndex{}, false, nil
+		}
+		for _, v := range info.versions {
+			if v.Version.String() == info.candidate &&
+				aptVersionSatisfies(possibility.Version, v.Version) {
+				return v, true, nil
+			}
+		}
+		for _, v := range info.versions {
+			if aptVersionSatisfies(possibility.Version, v.Version) {
+				return v, true, nil
+			}
+		}
+		return control.BinaryIndex{}, false, nil
+	}
+	if err = r.prefetch(info.providers); err != nil {
+		return control.BinaryIndex{}, false, err
+	}
+	providers := []control.BinaryIndex{}
+	for _, name := range info.providers {
+		if _, conflict := r.resolved[name]; conflict {
+			continue
+		}
+		provider, ok, err := r.choose(dependency.Possibility{Name: name})
+		if err != nil {
+			return control.BinaryIndex{}, false, err
+		}
+		if ok && aptProvides(provider, possibility) {
+			providers = append(providers, provider)
+		}
+	}
+	if len(providers) == 0 {
+		return control.BinaryIndex{}, false, nil
+	}
+	slices.SortStableFunc(providers, func(a, b control.BinaryIndex) int {
+		if c := aptPriority(a.Priority) - aptPriority(b.Priority); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Package, b.Package)
+	})
+	return providers[0], true, nil
+}
+
+// conflict returns an error describing the first Conflicts or Breaks relation
+// between two packages of the closure, if any. A package conflicting with a
+// virtual package it provides itself only conflicts with the other
+// providers.
+func (r *aptResolver) conflict() error {
+	names := slices.Sorted(maps.Keys(r.resolved))
+	for _, name := range names {
+		p := r.resolved[name]
+		for _, field := range aptConflictFields {
+			relations := aptRelations(p, field)
+			for _, possibility := range relations.GetAllPossibilities() {
+				for _, other := range names {
+					o := r.resolved[other]
+					if other == name || !(aptProvides(o, possibility) ||
+						(o.Package == possibility.Name &&
+							aptVersionSatisfies(possibility.Version, o.Version))) {
+						continue
+					}
+					return fmt.Errorf(
+						"The apt packages %s and %s cannot be installed together, %s %s: %s.",
+						p.Package, o.Package, p.Package, field, possibility.String())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// aptProvides reports whether the package provides the possibility. A
+// versioned possibility requires a versioned Provides.
+func aptProvides(p control.BinaryIndex, possibility dependency.Possibility) bool {
+	provides := aptRelations(p, "Provides")
+	for _, provided := range provides.GetAllPossibilities() {
+		if provided.Name != possibility.Name {
+			continue
+		}
+		if possibility.Version == nil {
+			return true
+		}
+		if provided.Version == nil {
+			continue
+		}
+		v, err := version.Parse(provided.Version.Number)
+		if err == nil && possibility.Version.SatisfiedBy(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// aptRelations parses a relationship field of the package, such as
+// Provides or Recommends, which the index does not expose. An invalid field
+// is considered empty.
+func aptRelations(p control.BinaryIndex, field string) dependency.Dependency {
+	relations, err := dependency.Parse(p.Values[field])
+	if err != nil {
+		return dependency.Dependency{}
+	}
+	return *relations
+}
+
+// aptVersionSatisfies reports whether v satisfies the optional relation.
+func aptVersionSatisfies(relation *dependency.VersionRelation, v version.Version) bool {
+	return relation == nil || relation.SatisfiedBy(v)
+}
+
+// aptPriority returns the rank of the priority, lower being more important.
+func aptPriority(priority string) int {
+	if i := slices.Index(aptPriorities, priority); i != -1 {
+		return i
+	}
+	return len(aptPriorities)
+}
+
+// closure returns the resolved packages other than name, sorted by name.
+func (r *aptResolver) closure(name string) []control.BinaryIndex {
+	packages := []control.BinaryIndex{}
+	for _, key := range slices.Sorted(maps.Keys(r.resolved)) {
+		if key != name {
+			packages = append(packages, r.resolved[key])
+		}
+	}
+	return packages
+}
@@ -0,0 +1,284 @@
+package modules
+
+import (
+	"archive/tar"
+	"credo/logger"
+	"credo/project"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/CREDOProject/go-apt-client"
+)
+
+const (
+	// aptApplySystem installs the packages in the system with apt-get,
+	// which requires root.
+	aptApplySystem = "system"
+	// aptApplyRootless unpacks the packages into a prefix of the project.
+	aptApplyRootless = "rootless"
+)
+
+// Directory of the prefix the packages are unpacked into, in the project.
+const aptPrefixDirectory = "apt-prefix"
+
+// Control files which are run by dpkg, and are not run by a rootless apply.
+var aptMaintainerScripts = []string{"preinst", "postinst", "prerm", "postrm",
+	"config", "triggers"}
+
+// aptApplyMode returns the way the packages are applied. By default, they
+// are installed in the system when running as root, and unpacked into the
+// project otherwise.
+func aptApplyMode() (string, error) {
+	mode := userSetting("APT_APPLY")
+	if mode == "" {
+		mode = projectSettings.Apt.Apply
+	}
+	switch mode {
+	case aptApplySystem, aptApplyRootless:
+		return mode, nil
+	case "":
+		if os.Geteuid() == 0 {
+			return aptApplySystem, nil
+		}
+		return aptApplyRootless, nil
+	}
+	return "", fmt.Errorf("Unknown apt apply mode: %s", mode)
+}
+
+// aptPrefix returns the prefix the packages are unpacked into.
+func aptPrefix() (string, error) {
+	project, err := project.ProjectPath()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(*project, aptPrefixDirectory), nil
+}
+
+// unpackDebs unpacks the saved package files of the spells into the prefix,
+// without running their maintainer scripts, and writes the activation script
+// of the prefix. Packages installed in the system in the recorded version are
+// skipped, so that the libraries of the system are not shadowed.
+func unpackDebs(downloadPath string, spells []aptSpell) error {
+	prefix, err := aptPrefix()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(prefix, 0755); err != nil {
+		return err
+	}
+	for _, spell := range spells {
+		if aptInstalled(spell) {
+			continue
+		}
+		file := path.Join(downloadPath, spell.debFile())
+		if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s has not been saved, run `credo save` first.",
+				spell.pinned())
+		}
+		out, err := exec.Command("dpkg-deb", "--extract", file, prefix).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("unpacking %s: %v %s", spell.debFile(), err, out)
+		}
+		scripts, err := debMaintainerScripts(file)
+		if err != nil {
+			return err
+		}
+		if len(scripts) > 0 {
+			logger.Get().Printf("[apt/apply]: the %s of %s were not run, they require root.",
+				strings.Join(scripts, ", "), spell.pinned())
+		}
+	}
+	if err = relocatePrefix(prefix); err != nil {
+		return err
+	}
+	activate := path.Join(prefix, "activate")
+	err = os.WriteFile(activate, []byte(aptActivation(prefix)), 0644)
+	if err != nil {
+		return err
+	}
+	logger.Get().Printf("[apt/apply]: packages unpacked into %s, run `. %s` to use them.",
+		prefix, activate)
+	return nil
+}
+
+// aptInstalled reports whether the recorded version of the package is
+// installed in the system.
+func aptInstalled(spell aptSpell) bool {
+	packages, err := apt.Search(spell.Name)
+	if err != nil {
+		return false
+	}
+	for _, p := range packages {
+		if p.Name == spell.Name && p.Status == "installed" &&
+			p.Version == spell.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// debMaintainerScripts returns the maintainer scripts of the package file.
+func debMaintainerScripts(file string) ([]string, error) {
+	cmd := exec.Command("dpkg-deb", "--ctrl-tarfile", file)
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	scripts := []string{}
+	reader := tar.NewReader(output)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cmd.Wait()
+			return nil, fmt.Errorf("reading the control files of %s: %v",
+				filepath.Base(file), err)
+		}
+		name := path.Base(header.Name)
+		if slices.Contains(aptMaintainerScripts, name) {
+			scripts = append(scripts, name)
+		}
+	}
+	if err = cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("reading the control files of %s: %v",
+			filepath.Base(file), err)
+	}
+	return scripts, nil
+}
+
+// relocatePrefix rewrites the absolute paths of the unpacked packages which
+// point to files of the prefix: symbolic links, the prefix of pkg-config files
+// and the interpreter of scripts.
+func relocatePrefix(prefix string) error {
+	return filepath.WalkDir(prefix, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			return relocateSymlink(prefix, file)
+		case entry.Type().IsRegular() && strings.HasSuffix(file, ".pc"):
+			return relocatePkgConfig(prefix, file)
+		case entry.Type().IsRegular() && slices.Contains(
+			[]string{"bin", "sbin"}, filepath.Base(filepath.Dir(file))):
+			return relocateInterpreter(prefix, file)
+		}
+		return nil
+	})
+}
+
+// relocateSymlink makes an absolute link to a file of the prefix relative.
+func relocateSymlink(prefix string, file string) error {
+	target, err := os.Readlink(file)
+	if err != nil || !filepath.IsAbs(target) {
+		return err
+	}
+	relocated := filepath.Join(prefix, target)
+	if _, err := os.Lstat(relocated); err != nil {
+		return nil
+	}
+	relative, err := filepath.Rel(filepath.Dir(file), relocated)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(file); err != nil {
+		return err
+	}
+	return os.Symlink(relative, file)
+}
+
+// relocatePkgConfig moves the prefix variable of a pkg-config file into the
+// prefix.
+func relocatePkgConfig(prefix string, file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(content), "\n")
+	changed := false
+	for i, line := range lines {
+		value, found := strings.CutPrefix(line, "prefix=")
+		if found && filepath.IsAbs(value) && !strings.HasPrefix(value, prefix) {
+			lines[i] = "prefix=" + filepath.Join(prefix, value)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return os.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644)
+}
+
+// relocateInterpreter points the interpreter of a script to the prefix, when
+// the prefix provides it.
+func relocateInterpreter(prefix string, file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil || !strings.HasPrefix(string(content), "#!/") {
+		return err
+	}
+	shebang, rest, _ := strings.Cut(string(content), "\n")
+	interpreter, arguments, _ := strings.Cut(strings.TrimPrefix(shebang, "#!"), " ")
+	if strings.HasPrefix(interpreter, prefix) {
+		return nil
+	}
+	relocated := filepath.Join(prefix, interpreter)
+	if _, err := os.Stat(relocated); err != nil {
+		return nil
+	}
+	shebang = "#!" + relocated
+	if arguments != "" {
+		shebang += " " + arguments
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(shebang+"\n"+rest), info.Mode().Perm())
+}
+
+// aptActivation returns the shell script adding the directories of the
+// prefix to the environment.
+func aptActivation(prefix string) string {
+	variables := []struct {
+		name        string
+		directories []string
+	}{
+		{"PATH", []string{"usr/local/bin", "usr/bin", "bin", "usr/sbin", "sbin"}},
+		{"LD_LIBRARY_PATH", []string{"usr/lib/*-linux-*", "usr/lib",
+			"lib/*-linux-*", "lib"}},
+		{"PKG_CONFIG_PATH", []string{"usr/lib/*-linux-*/pkgconfig",
+			"usr/lib/pkgconfig", "usr/share/pkgconfig"}},
+	}
+	lines := []string{"# This file is automatically generated by CREDO."}
+	for _, variable := range variables {
+		directories := []string{}
+		for _, pattern := range variable.directories {
+			matches, _ := filepath.Glob(filepath.Join(prefix, pattern))
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && info.IsDir() {
+					directories = append(directories, match)
+				}
+			}
+		}
+		if len(directories) == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf(
+			`export %s="%s${%s:+:$%s}"`, variable.name,
+			strings.Join(directories, ":"), variable.name, variable.name))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,153 @@
+package modules
+
+import (
+	"credo/logger"
+	"credo/project"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"pault.ag/go/debian/control"
+)
+
+// File touched in the apt state directory of the project after every refresh
+// by CREDO, as apt keeps the lists which did not change untouched.
+const aptUpdatedStamp = "updated"
+
+// aptSession is shared by the apt lookups of a run, so that the package lists
+// are refreshed at most once and every package is looked up once.
+type aptSession struct {
+	architecture string
+	updated      bool
+	packages     map[string]*aptPackageInfo
+}
+
+// Session of the current run, created on first use.
+var aptCurrentSession *aptSession
+
+// getAptSession returns the session of the current run.
+func getAptSession() (*aptSession, error) {
+	if aptCurrentSession != nil {
+		return aptCurrentSession, nil
+	}
+	output, err := exec.Command("dpkg", "--print-architecture").Output()
+	if err != nil {
+		return nil, fmt.Errorf("retrieving the dpkg architecture: %v", err)
+	}
+	aptCurrentSession = &aptSession{
+		architecture: strings.TrimSpace(string(output)),
+		packages:     map[string]*aptPackageInfo{},
+	}
+	return aptCurrentSession, nil
+}
+
+// aptMaxAge returns the age under which the package lists are not refreshed.
+// Zero means that they are refreshed once per run.
+func aptMaxAge() (time.Duration, error) {
+	maxAge := userSetting("APT_MAX_AGE")
+	if maxAge == "" {
+		maxAge = projectSettings.Apt.MaxAge
+	}
+	if maxAge == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(maxAge)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid apt max age %s: %v", maxAge, err)
+	}
+	return duration, nil
+}
+
+// aptUpdatedStampFile returns the stamp of the refreshes, in the project.
+func aptUpdatedStampFile() (string, error) {
+	project, err := project.ProjectPath()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(*project, aptStateDirectory, aptUpdatedStamp), nil
+}
+
+// aptListsAge returns the time elapsed since the last refresh of the package
+// lists in directory, or an error if there are none. The stamp counts as a
+// refresh when there are lists.
+func aptListsAge(directory string, stamp string) (time.Duration, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return 0, err
+	}
+	var newest time.Time
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == "lock" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	if newest.IsZero() {
+		return 0, fmt.Errorf("%s has no package lists.", directory)
+	}
+	if info, err := os.Stat(stamp); err == nil && info.ModTime().After(newest) {
+		newest = info.ModTime()
+	}
+	return time.Since(newest), nil
+}
+
+// update refreshes the package lists, unless they were already refreshed
+// during the run or are younger than the maximum age.
+func (s *aptSession) update() error {
+	if s.updated {
+		return nil
+	}
+	maxAge, err := aptMaxAge()
+	if err != nil {
+		return err
+	}
+	lists, err := aptListsDirectory()
+	if err != nil {
+		return err
+	}
+	stamp, err := aptUpdatedStampFile()
+	if err != nil {
+		return err
+	}
+	if age, err := aptListsAge(lists, stamp); maxAge > 0 && err == nil && age < maxAge {
+		logger.Get().Printf("[apt/update]: package lists are %s old, not refreshing.",
+			age.Round(time.Second))
+		s.updated = true
+		return nil
+	}
+	if output, err := aptUpdate(); err != nil {
+		return fmt.Errorf("failed to check for updates: %v\n%s", err, output)
+	}
+	// The stamp is best effort, the lists are then only considered older.
+	if err := os.MkdirAll(path.Dir(stamp), 0755); err == nil {
+		if file, err := os.Create(stamp); err == nil {
+			file.Close()
+		}
+	}
+	s.updated = true
+	// Metadata read before the refresh may be outdated.
+	s.packages = map[string]*aptPackageInfo{}
+	return nil
+}
+
+// resolver returns a resolver sharing the package metadata of the session.
+func (s *aptSession) resolver() *aptResolver {
+	architecture := s.architecture
+	return &aptResolver{
+		architecture: architecture,
+		lookup: func(names []string) (map[string]*aptPackageInfo, error) {
+			return aptCacheLookup(names, architecture)
+		},
+		packages: s.packages,
+		resolved: map[string]control.BinaryIndex{},
+	}
+}
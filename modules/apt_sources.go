@@ -0,0 +1,216 @@
+package modules
+
+import (
+	"credo/project"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Directory of the private apt configuration and state, in the project.
+const aptStateDirectory = "apt-state"
+
+// Layout of the timestamps of snapshot.debian.org and snapshot.ubuntu.com.
+const aptSnapshotLayout = "20060102T150405Z"
+
+// Hosts of the archives serving snapshots under a timestamp.
+var aptSnapshotHosts = []string{"snapshot.debian.org", "snapshot.ubuntu.com"}
+
+// aptSettings is the project-level configuration of the aptModule.
+type aptSettings struct {
+	// Sources replace the sources of the host when set. The package lists
+	// are then kept in the project, apart from the ones of the host.
+	Sources []aptSource `yaml:"sources,omitempty"`
+	// Snapshot is the timestamp, such as 20240101T000000Z, appended to the
+	// URI of the sources on a snapshot archive, following their layout.
+	// Other sources, such as the local ones, are used as they are.
+	Snapshot string `yaml:"snapshot,omitempty"`
+	// MaxAge is the age, such as 24h, under which the package lists are
+	// not refreshed. They are refreshed once per run when empty. It can be
+	// overridden by the CREDO_APT_MAX_AGE environment variable.
+	MaxAge string `yaml:"max_age,omitempty"`
+	// Apply is the way the packages are applied: system, installing them
+	// with apt-get, or rootless, unpacking them into the project. When
+	// empty, they are installed in the system only when running as root. It
+	// can be overridden by the CREDO_APT_APPLY environment variable.
+	Apply string `yaml:"apply,omitempty"`
+	// Recommends and Suggests are the policies, include or exclude, of the
+	// spells without their own. Both are excluded when empty.
+	Recommends string `yaml:"recommends,omitempty"`
+	Suggests   string `yaml:"suggests,omitempty"`
+}
+
+// aptSource is an entry of the sources list.
+type aptSource struct {
+	// URI of the archive, such as https://snapshot.debian.org/archive/debian.
+	URI string `yaml:"uri"`
+	// Suites, such as bookworm, or the path of a flat repository ending
+	// with a slash.
+	Suites     []string `yaml:"suites"`
+	Components []string `yaml:"components,omitempty"`
+	// SignedBy is the keyring verifying the archive. The keyrings of the
+	// host are used when empty.
+	SignedBy string `yaml:"signed_by,omitempty"`
+	// Trusted disables the verification of the archive, for local archives.
+	Trusted bool `yaml:"trusted,omitempty"`
+}
+
+// snapshotCapable reports whether the source is a remote archive serving
+// snapshots. Trusted sources are local archives and never are.
+func (s aptSource) snapshotCapable() bool {
+	if s.Trusted {
+		return false
+	}
+	uri, err := url.Parse(s.URI)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(aptSnapshotHosts, uri.Hostname())
+}
+
+// lines returns the lines of the sources list describing the source.
+func (s aptSource) lines(snapshot string) []string {
+	options := []string{}
+	if s.Trusted {
+		options = append(options, "trusted=yes")
+	}
+	if s.SignedBy != "" {
+		options = append(options, "signed-by="+s.SignedBy)
+	}
+	prefix := "deb "
+	if len(options) > 0 {
+		prefix += "[" + strings.Join(options, " ") + "] "
+	}
+	uri := strings.TrimSuffix(s.URI, "/")
+	if snapshot != "" && s.snapshotCapable() {
+		uri += "/" + snapshot
+	}
+	lines := []string{}
+	for _, suite := range s.Suites {
+		line := prefix + uri + " " + suite
+		if len(s.Components) > 0 {
+			line += " " + strings.Join(s.Components, " ")
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// validate checks that the snapshot is a timestamp and that every source is
+// complete.
+func (s aptSettings) validate() error {
+	if s.Snapshot != "" {
+		if _, err := time.Parse(aptSnapshotLayout, s.Snapshot); err != nil {
+			return fmt.Errorf("%w apt snapshot %s is not a timestamp such as %s.",
+				ErrInvalidSpell, s.Snapshot, aptSnapshotLayout)
+		}
+	}
+	for _, source := range s.Sources {
+		if source.URI == "" || len(source.Suites) == 0 {
+			return fmt.Errorf("%w apt sources require an uri and suites.",
+				ErrInvalidSpell)
+		}
+	}
+	return nil
+}
+
+// sourcesList returns the content of the private sources list.
+func (s aptSettings) sourcesList() string {
+	lines := []string{"# This file is automatically generated by CREDO."}
+	for _, source := range s.Sources {
+		lines = append(lines, source.lines(s.Snapshot)...)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// aptOptions returns the options pointing apt to the private sources list
+// and state of the project, writing the sources list. Without project
+// sources, the configuration of the host is used and there are no options.
+func aptOptions() ([]string, error) {
+	settings := projectSettings.Apt
+	if len(settings.Sources) == 0 {
+		return nil, nil
+	}
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+	project, err := project.ProjectPath()
+	if err != nil {
+		return nil, err
+	}
+	state := path.Join(*project, aptStateDirectory)
+	// Empty directories hide the sources and preferences of the host.
+	for _, directory := range []string{"lists/partial", "cache/archives/partial",
+		"sources.list.d", "preferences.d"} {
+		if err = os.MkdirAll(path.Join(state, directory), 0755); err != nil {
+			return nil, err
+		}
+	}
+	sourcesList := path.Join(state, "sources.list")
+	err = os.WriteFile(sourcesList, []byte(settings.sourcesList()), 0644)
+	if err != nil {
+		return nil, err
+	}
+	options := []string{
+		"Dir::Etc::SourceList=" + sourcesList,
+		"Dir::Etc::SourceParts=" + path.Join(state, "sources.list.d"),
+		"Dir::Etc::Preferences=" + path.Join(state, "preferences"),
+		"Dir::Etc::PreferencesParts=" + path.Join(state, "preferences.d"),
+		"Dir::State::Lists=" + path.Join(state, "lists"),
+		"Dir::Cache=" + path.Join(state, "cache"),
+		// The unprivileged user of apt cannot write into the project.
+		"APT::Sandbox::User=root",
+	}
+	if settings.Snapshot != "" {
+		// The Release files of a snapshot are expired by design.
+		options = append(options, "Acquire::Check-Valid-Until=false")
+	}
+	args := []string{}
+	for _, option := range options {
+		args = append(args, "-o", option)
+	}
+	return args, nil
+}
+
+// aptListsDirectory returns the directory of the package lists used by the
+// aptModule.
+func aptListsDirectory() (string, error) {
+	if len(projectSettings.Apt.Sources) == 0 {
+		output, err := exec.Command("apt-config", "shell", "LISTS",
+			"Dir::State::Lists/d").Output()
+		if err != nil {
+			return "", fmt.Errorf("retrieving the apt lists directory: %v", err)
+		}
+		_, lists, _ := strings.Cut(strings.TrimSpace(string(output)), "=")
+		return strings.Trim(lists, "'"), nil
+	}
+	project, err := project.ProjectPath()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(*project, aptStateDirectory, "lists"), nil
+}
+
+// aptCommand returns the apt command, apt-get or apt-cache, using the sources
+// of the project.
+func aptCommand(name string, args ...string) (*exec.Cmd, error) {
+	options, err := aptOptions()
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(name, append(options, args...)...), nil
+}
+
+// aptUpdate refreshes the package lists of the sources.
+func aptUpdate() ([]byte, error) {
+	cmd, err := aptCommand("apt-get", "update", "-y")
+	if err != nil {
+		return nil, err
+	}
+	return cmd.CombinedOutput()
+}
@@ -0,0 +1,540 @@
+package modules
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"pault.ag/go/debian/control"
+	"pault.ag/go/debian/dependency"
+)
+
+const aptTestIndex = `Package: samtools
+Version: 1.16.1-1
+Architecture: amd64
+Depends: libc6 (>= 2.34), libhts3 (>= 1.16), mawk | awk
+Recommends: curl, missing (>= 1)
+Suggests: original-awk
+
+Package: libc6
+Version: 2.36-9
+Architecture: amd64
+Priority: optional
+
+Package: libc6
+Version: 2.33-1
+Architecture: amd64
+
+Package: libhts3
+Version: 1.16+ds-3
+Architecture: amd64
+Pre-Depends: zlib1g (>= 1:1.2.0)
+Depends: libc6
+
+Package: libhts3
+Version: 1.16+ds-3
+Architecture: arm64
+
+Package: zlib1g
+Version: 1:1.2.13-1
+Architecture: amd64
+Depends: libc6 (>= 2.14)
+
+Package: gawk
+Version: 1:5.2.1-2
+Architecture: amd64
+Priority: optional
+Provides: awk
+
+Package: original-awk
+Version: 2022-09-12-1
+Architecture: amd64
+Priority: optional
+Provides: awk
+
+Package: curl
+Version: 7.88.1-10
+Architecture: amd64
+Depends: awk
+`
+
+// aptTestResolver returns a resolver reading the index.
+func aptTestResolver(t *testing.T, index string) *aptResolver {
+	versions, err := parseAptIndex([]byte(index), "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &aptResolver{
+		architecture: "amd64",
+		lookup: func(names []string) (map[string]*aptPackageInfo, error) {
+			infos := map[string]*aptPackageInfo{}
+			for _, name := range names {
+				info := &aptPackageInfo{}
+				for _, v := range versions {
+					if v.Package == name {
+						info.versions = append(info.versions, v)
+					}
+					if aptProvides(v, dependency.Possibility{Name: name}) {
+						info.providers = append(info.providers, v.Package)
+					}
+				}
+				infos[name] = info
+			}
+			return infos, nil
+		},
+		packages: map[string]*aptPackageInfo{},
+		resolved: map[string]control.BinaryIndex{},
+	}
+}
+
+func Test_aptResolverResolve(t *testing.T) {
+	tests := []struct {
+		relation string
+		expected []string
+		wantErr  bool
+	}{
+		// Alternatives fall back to a virtual package, whose provider is
+		// chosen by name among equal priorities.
+		{"samtools", []string{"gawk=1:5.2.1-2", "libc6=2.36-9",
+			"libhts3=1.16+ds-3", "samtools=1.16.1-1", "zlib1g=1:1.2.13-1"}, false},
+		{"libc6 (<< 2.34)", []string{"libc6=2.33-1"}, false},
+		{"curl", []string{"curl=7.88.1-10", "gawk=1:5.2.1-2"}, false},
+		{"missing | zlib1g", []string{"libc6=2.36-9", "zlib1g=1:1.2.13-1"}, false},
+		{"libhts3 (>= 1.17)", nil, true},
+	}
+	for _, test := range tests {
+		resolver := aptTestResolver(t, aptTestIndex)
+		relation, err := dependency.Parse(test.relation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = resolver.resolve(relation.Relations[0])
+		if (err != nil) != test.wantErr {
+			t.Fatalf("resolve(%s) error = %v, wantErr %v", test.relation, err,
+				test.wantErr)
+		}
+		if test.wantErr {
+			continue
+		}
+		got := []string{}
+		for _, p := range resolver.closure("") {
+			got = append(got, p.Package+"="+p.Version.String())
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("resolve(%s) = %v, want %v", test.relation, got,
+				test.expected)
+		}
+	}
+}
+
+func Test_parseAptCandidates(t *testing.T) {
+	output := `awk:
+  Installed: (none)
+  Candidate: (none)
+  Version table:
+mawk:
+  Installed: 1.3.4.20200120-3.1
+  Candidate: 1.3.4.20200120-3.1
+  Version table:
+ *** 1.3.4.20200120-3.1 500
+        500 http://deb.debian.org/debian bookworm/main amd64 Packages
+`
+	expected := map[string]string{"mawk": "1.3.4.20200120-3.1"}
+	if got := parseAptCandidates([]byte(output)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseAptCandidates() = %v, want %v", got, expected)
+	}
+}
+
+func Test_aptPinnedName(t *testing.T) {
+	tests := map[string]string{
+		"samtools":            "samtools",
+		"samtools=1.19-1":     "samtools (= 1.19-1)",
+		"zlib1g=1:1.2.13-1":   "zlib1g (= 1:1.2.13-1)",
+		"libc6 (>= 2.34)":     "libc6 (>= 2.34)",
+		"mawk | original-awk": "mawk | original-awk",
+	}
+	for name, expected := range tests {
+		if got := aptPinnedName(name); got != expected {
+			t.Errorf("aptPinnedName(%s) = %s, want %s", name, got, expected)
+		}
+	}
+}
+
+func Test_aptSpellDebFile(t *testing.T) {
+	spell := aptSpell{Name: "zlib1g", Version: "1:1.2.13.dfsg-1",
+		Architecture: "amd64"}
+	expected := "zlib1g_1%3a1.2.13.dfsg-1_amd64.deb"
+	if got := spell.debFile(); got != expected {
+		t.Errorf("debFile() = %s, want %s", got, expected)
+	}
+}
+
+func Test_aptSettingsSourcesList(t *testing.T) {
+	settings := aptSettings{
+		Snapshot: "20240101T000000Z",
+		Sources: []aptSource{
+			{
+				URI:        "https://snapshot.debian.org/archive/debian/",
+				Suites:     []string{"bookworm", "bookworm-updates"},
+				Components: []string{"main", "contrib"},
+			},
+			{
+				URI:     "http://localhost:8000/local",
+				Suites:  []string{"./"},
+				Trusted: true,
+			},
+			{
+				URI:     "file:/srv/credoenv/apt",
+				Suites:  []string{"./"},
+				Trusted: true,
+			},
+			{
+				URI:    "https://deb.example.org/debian",
+				Suites: []string{"bookworm"},
+			},
+		},
+	}
+	if err := settings.validate(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# This file is automatically generated by CREDO.
+deb https://snapshot.debian.org/archive/debian/20240101T000000Z bookworm main contrib
+deb https://snapshot.debian.org/archive/debian/20240101T000000Z bookworm-updates main contrib
+deb [trusted=yes] http://localhost:8000/local ./
+deb [trusted=yes] file:/srv/credoenv/apt ./
+deb https://deb.example.org/debian bookworm
+`
+	if got := settings.sourcesList(); got != expected {
+		t.Errorf("sourcesList() = %s, want %s", got, expected)
+	}
+	settings.Snapshot = "2024-01-01"
+	if err := settings.validate(); err == nil {
+		t.Errorf("validate() accepted the snapshot %s", settings.Snapshot)
+	}
+}
+
+func Test_aptListsAge(t *testing.T) {
+	directory := t.TempDir()
+	stamp := filepath.Join(t.TempDir(), aptUpdatedStamp)
+	if _, err := aptListsAge(directory, stamp); err == nil {
+		t.Errorf("aptListsAge() of an empty directory succeeded")
+	}
+	lists := filepath.Join(directory, "deb.debian.org_debian_dists_bookworm_InRelease")
+	if err := os.WriteFile(lists, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(lists, old, old); err != nil {
+		t.Fatal(err)
+	}
+	age, err := aptListsAge(directory, stamp)
+	if err != nil || age < 47*time.Hour {
+		t.Errorf("aptListsAge() = %v, %v, want 48h", age, err)
+	}
+	if err := os.WriteFile(stamp, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if age, err = aptListsAge(directory, stamp); err != nil || age > time.Hour {
+		t.Errorf("aptListsAge() = %v, %v, want less than 1h", age, err)
+	}
+}
+
+func Test_relocatePrefix(t *testing.T) {
+	prefix := t.TempDir()
+	for _, directory := range []string{"usr/bin", "usr/lib/x86_64-linux-gnu/pkgconfig",
+		"etc/alternatives"} {
+		if err := os.MkdirAll(filepath.Join(prefix, directory), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"usr/bin/python3":     "\x7fELF",
+		"usr/bin/tool":        "#!/usr/bin/python3 -u\nprint()\n",
+		"usr/bin/system-tool": "#!/bin/sh\necho\n",
+		"usr/lib/x86_64-linux-gnu/pkgconfig/hts.pc": "prefix=/usr\nlibdir=${prefix}/lib\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(prefix, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/usr/bin/tool", filepath.Join(prefix, "etc/alternatives/tool")); err != nil {
+		t.Fatal(err)
+	}
+	if err := relocatePrefix(prefix); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"usr/bin/tool":        "#!" + prefix + "/usr/bin/python3 -u\nprint()\n",
+		"usr/bin/system-tool": "#!/bin/sh\necho\n",
+		"usr/lib/x86_64-linux-gnu/pkgconfig/hts.pc": "prefix=" + prefix +
+			"/usr\nlibdir=${prefix}/lib\n",
+	}
+	for name, content := range expected {
+		got, err := os.ReadFile(filepath.Join(prefix, name))
+		if err != nil || string(got) != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	target, err := os.Readlink(filepath.Join(prefix, "etc/alternatives/tool"))
+	if err != nil || target != "../../usr/bin/tool" {
+		t.Errorf("etc/alternatives/tool -> %s, want ../../usr/bin/tool", target)
+	}
+	activation := aptActivation(prefix)
+	for _, line := range []string{
+		`export PATH="` + prefix + `/usr/bin${PATH:+:$PATH}"`,
+		`export LD_LIBRARY_PATH="` + prefix + `/usr/lib/x86_64-linux-gnu:` +
+			prefix + `/usr/lib${LD_LIBRARY_PATH:+:$LD_LIBRARY_PATH}"`,
+	} {
+		if !strings.Contains(activation, line) {
+			t.Errorf("aptActivation() = %s, want %s", activation, line)
+		}
+	}
+}
+
+// writeAptTestDeb writes a package file with the control file and no data.
+func writeAptTestDeb(t *testing.T, file string, control string) {
+	tarball := func(files map[string]string) []byte {
+		var buffer bytes.Buffer
+		compressed := gzip.NewWriter(&buffer)
+		archive := tar.NewWriter(compressed)
+		for name, content := range files {
+			err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0644,
+				Size: int64(len(content))})
+			if err == nil {
+				_, err = archive.Write([]byte(content))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := archive.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := compressed.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.Bytes()
+	}
+	var ar bytes.Buffer
+	ar.WriteString("!<arch>\n")
+	for _, member := range []struct {
+		name    string
+		content []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", tarball(map[string]string{"./control": control})},
+		{"data.tar.gz", tarball(nil)},
+	} {
+		fmt.Fprintf(&ar, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", member.name, 0, 0, 0,
+			"100644", len(member.content))
+		ar.Write(member.content)
+		if len(member.content)%2 == 1 {
+			ar.WriteString("\n")
+		}
+	}
+	if err := os.WriteFile(file, ar.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_aptRepositoryIndex(t *testing.T) {
+	repository := t.TempDir()
+	writeAptTestDeb(t, filepath.Join(repository, "tool_1.0-1_amd64.deb"),
+		`Package: tool
+Version: 1.0-1
+Architecture: amd64
+Depends: libc6 (>= 2.34)
+Description: a tool
+ The tool does one thing.
+ .
+ And does it well.
+`)
+	index, architectures, err := aptRepositoryIndex(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes, err := hashAptFile(filepath.Join(repository, "tool_1.0-1_amd64.deb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(`Package: tool
+Version: 1.0-1
+Architecture: amd64
+Depends: libc6 (>= 2.34)
+Description: a tool
+ The tool does one thing.
+ .
+ And does it well.
+Filename: ./tool_1.0-1_amd64.deb
+Size: %d
+MD5sum: %s
+SHA1: %s
+SHA256: %s
+`, hashes.size, hashes.md5, hashes.sha1, hashes.sha256)
+	if string(index) != expected {
+		t.Errorf("aptRepositoryIndex() = %s, want %s", index, expected)
+	}
+	if !reflect.DeepEqual(architectures, []string{"amd64"}) {
+		t.Errorf("aptRepositoryIndex() architectures = %v, want [amd64]",
+			architectures)
+	}
+}
+
+func Test_aptRelease(t *testing.T) {
+	files := map[string][]byte{"Packages": []byte("Package: a\n")}
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expected := `Origin: CREDO
+Label: CREDO
+Date: Mon, 01 Jan 2024 00:00:00 +0000
+Architectures: amd64
+MD5Sum:
+ 51e6edca135dcb3909a88db45e8485a4 11 Packages
+SHA1:
+ 9a6333811c30a4a39916ff38f5d93a00b8925287 11 Packages
+SHA256:
+ fa07db62b48a0f9848538c437cfa8aef500bd6570830a5c508d87eeb99d1a50d 11 Packages
+`
+	if got := aptRelease([]string{"amd64"}, files, date); got != expected {
+		t.Errorf("aptRelease() = %s, want %s", got, expected)
+	}
+}
+
+func Test_aptResolverResolveOptional(t *testing.T) {
+	tests := []struct {
+		fields   []string
+		expected []string
+	}{
+		{nil, []string{}},
+		{[]string{"Recommends"}, []string{"curl"}},
+		{[]string{"Recommends", "Suggests"}, []string{"curl", "original-awk"}},
+	}
+	for _, test := range tests {
+		resolver := aptTestResolver(t, aptTestIndex)
+		relation, err := dependency.Parse("samtools")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = resolver.resolve(relation.Relations[0]); err != nil {
+			t.Fatal(err)
+		}
+		if got := resolver.resolveOptional(test.fields); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("resolveOptional(%v) = %v, want %v", test.fields, got,
+				test.expected)
+		}
+	}
+}
+
+const aptTestConflictIndex = `Package: pipeline
+Version: 1.0-1
+Architecture: amd64
+Depends: reader, writer
+Recommends: legacy-writer
+
+Package: reader
+Version: 2.0-1
+Architecture: amd64
+Breaks: writer (<< 2)
+
+Package: writer
+Version: 1.5-1
+Architecture: amd64
+
+Package: writer
+Version: 2.1-1
+Architecture: amd64
+Provides: writer-backend
+Conflicts: writer-backend
+
+Package: legacy-writer
+Version: 1.0-1
+Architecture: amd64
+Provides: writer-backend
+`
+
+func Test_aptResolverConflict(t *testing.T) {
+	// writer only conflicts with the other providers of writer-backend.
+	for _, name := range []string{"pipeline", "legacy-writer", "writer (<< 2)"} {
+		resolver := aptTestResolver(t, aptTestConflictIndex)
+		relation, err := dependency.Parse(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = resolver.resolve(relation.Relations[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err = resolver.conflict(); err != nil {
+			t.Errorf("conflict() after %s = %v", name, err)
+		}
+	}
+
+	// A pinned version of writer is broken by reader.
+	resolver := aptTestResolver(t, aptTestConflictIndex)
+	for _, name := range []string{"writer (<< 2)", "reader"} {
+		relation, err := dependency.Parse(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = resolver.resolve(relation.Relations[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "The apt packages reader and writer cannot be installed together, " +
+		"reader Breaks: writer (<< 2)."
+	if err := resolver.conflict(); err == nil || err.Error() != expected {
+		t.Errorf("conflict() = %v, want %s", err, expected)
+	}
+
+	// The recommended legacy-writer conflicts with writer and is skipped.
+	resolver = aptTestResolver(t, aptTestConflictIndex)
+	relation, err := dependency.Parse("pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = resolver.resolve(relation.Relations[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := resolver.resolveOptional([]string{"Recommends"}); len(got) != 0 {
+		t.Errorf("resolveOptional() = %v, want none", got)
+	}
+}
+
+func Test_aptPolicy(t *testing.T) {
+	tests := []struct {
+		spell, project, expected string
+		wantErr                  bool
+	}{
+		{"", "", aptPolicyExclude, false},
+		{"", aptPolicyInclude, aptPolicyInclude, false},
+		{aptPolicyExclude, aptPolicyInclude, aptPolicyExclude, false},
+		{"maybe", "", "", true},
+	}
+	for _, test := range tests {
+		got, err := aptPolicy("recommends", test.spell, test.project)
+		if got != test.expected || (err != nil) != test.wantErr {
+			t.Errorf("aptPolicy(%s, %s) = %s, %v, want %s", test.spell,
+				test.project, got, err, test.expected)
+		}
+	}
+}
+
+func Test_aptUnknownNamesOnly(t *testing.T) {
+	tests := map[string]bool{
+		"N: Unable to locate package foo\nE: No packages found\n":                                  true,
+		"N: Can't select versions from package 'awk' as it is purely virtual\n":                    true,
+		"E: Could not open lock file /var/lib/dpkg/lock-frontend - open (13: Permission denied)\n": false,
+		"E: The package cache file is corrupted\n":                                                 false,
+	}
+	for stderr, want := range tests {
+		if got := aptUnknownNamesOnly(stderr); got != want {
+			t.Errorf("aptUnknownNamesOnly(%q) = %v, want %v", stderr, got, want)
+		}
+	}
+}
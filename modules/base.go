@@ -3,6 +3,7 @@ package modules
 import (
 	"credo/logger"
 	"errors"
+	"fmt"
 
 	"github.com/spf13/cobra"
 )
@@ -15,6 +16,10 @@ var (
 	// ErrConverting SHOULD be used by a Module to communicate an error in
 	// converting a Spell.
 	ErrConverting = errors.New("Error converting spell.")
+
+	// ErrInvalidSpell SHOULD be used by a Module to reject a Spell which
+	// cannot be committed or saved.
+	ErrInvalidSpell = errors.New("Invalid spell:")
 )
 
 // equatable is an interface that provides a method to check equality between
@@ -80,7 +85,9 @@ func Register(moduleName string, module ModuleFactory) {
 }
 
 // Registers modules to a subcommand.
+// The settings of config become the settings of the project.
 func RegisterModulesCli(cmd *cobra.Command, config *Config) {
+	projectSettings = &config.Settings
 	for _, module := range Modules {
 		if cfg := module().CliConfig(config); cfg != nil && cmd != cfg {
 			cmd.AddCommand(cfg)
@@ -88,6 +95,57 @@ func RegisterModulesCli(cmd *cobra.Command, config *Config) {
 	}
 }
 
+// runModuleCli runs the command line of the module on the arguments, as if
+// called by the user, committing into the config.
+func runModuleCli(module ModuleFactory, config *Config, args []string) {
+	command := module().CliConfig(config)
+	command.Run(command, args)
+}
+
+// recordSystemPackages records the system packages, indexed by package
+// manager, into the config with the package managers of the host.
+func recordSystemPackages(config *Config, packages map[string][]string) {
+	for manager, names := range packages {
+		module, ok := Modules[manager]
+		if !ok {
+			continue
+		}
+		for _, name := range names {
+			runModuleCli(module, config, []string{name})
+		}
+	}
+}
+
+// commitSystemPackage resolves the system package with the package manager
+// and commits it into the config. Unlike the command line, it returns the
+// errors, so that another package can be tried.
+func commitSystemPackage(manager string, config *Config, name string) error {
+	var spell any
+	var err error
+	switch manager {
+	case aptModuleName:
+		spell, err = (&aptModule{}).bareRun(aptSpell{Name: name})
+	case dnfModuleName:
+		spell, err = (&dnfModule{}).bareRun(dnfSpell{Name: name})
+	case apkModuleName:
+		spell, err = (&apkModule{}).bareRun(apkSpell{Name: name})
+	default:
+		return fmt.Errorf("Unknown package manager: %s", manager)
+	}
+	if err != nil {
+		return err
+	}
+	module, ok := Modules[manager]
+	if !ok {
+		return fmt.Errorf("Unknown package manager: %s", manager)
+	}
+	err = module().Commit(config, spell)
+	if err != nil && err != ErrAlreadyPresent {
+		return err
+	}
+	return nil
+}
+
 // DeepSave all sub-dependency of a spell.
 func DeepSave(config *Config) error {
 	for _, module := range Modules {
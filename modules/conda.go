@@ -5,12 +5,10 @@ import (
 	"credo/logger"
 	"credo/project"
 	"fmt"
-	"os"
 	"path"
+	"slices"
 	"strings"
 
-	goconda "github.com/CREDOProject/go-conda"
-	condautils "github.com/CREDOProject/go-conda/utils"
 	"github.com/CREDOProject/sharedutils/types"
 	"github.com/spf13/cobra"
 )
@@ -25,6 +23,12 @@ Install a conda package:
 
 Install a conda package from a channel:
 	credo conda scipy --channel=bioconda
+
+Install a conda package from ordered channels with strict priority:
+	credo conda samtools --channel=conda-forge,bioconda --channel-priority=strict
+
+Install a conda package pinned to a channel:
+	credo conda bioconda::samtools
 `
 
 // Registers the condaModule.
@@ -33,20 +37,60 @@ func init() { Register(condaModuleName, func() Module { return &condaModule{} })
 // condaModule is used to manage the conda scope in the credospell configuration.
 type condaModule struct{}
 
+// Directory of the conda environment of the project.
+const condaEnvironmentDirectory = "condaenv"
+
 // Apply implements Module.
-func (c *condaModule) Apply(any) error {
-	return nil
+//
+// The saved packages of the solution are installed exactly, without solving
+// again, from the package cache of the project into its conda environment.
+func (c *condaModule) Apply(anySpell any) error {
+	spell, err := types.To[condaSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	locks, err := condaLocks([]condaSpell{*spell})
+	if err != nil {
+		return err
+	}
+	if len(locks) != 1 {
+		return fmt.Errorf("conda spell %s targets %d platforms.", spell.Name,
+			len(locks))
+	}
+	project, err := project.ProjectPath()
+	if err != nil {
+		return err
+	}
+	backend, err := newCondaBackend()
+	if err != nil {
+		return fmt.Errorf("Apply, detecting conda backend: %v", err)
+	}
+	for _, lock := range locks {
+		err = backend.install(path.Join(*project, condaModuleName),
+			path.Join(*project, condaEnvironmentDirectory), lock)
+	}
+	return err
 }
 
 // BulkApply implements Module.
 func (c *condaModule) BulkApply(config *Config) error {
+	for _, cs := range config.Conda {
+		if err := c.Apply(cs); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 type condaSpell struct {
-	Name                 string `yaml:"name"`
-	Channel              string `yaml:"channel,omitempty"`
-	ExternalDependencies Config `yaml:"external_dependencies,omitempty"`
+	Name string `yaml:"name"`
+	// Channel pins the package, but not its dependencies, to a channel, as
+	// the `channel::` prefix of a match specification does.
+	Channel              string         `yaml:"channel,omitempty"`
+	Channels             []string       `yaml:"channels,omitempty"`
+	ChannelPriority      string         `yaml:"channel_priority,omitempty"`
+	Packages             []condaPackage `yaml:"packages,omitempty"`
+	ExternalDependencies Config         `yaml:"external_dependencies,omitempty"`
 }
 
 // Function used to check if two condaSpell objects are equal.
@@ -54,8 +98,8 @@ type condaSpell struct {
 // value indicating whether the two objects are equal or not.
 // The function first checks if the input parameter t is of type condaSpell.
 //
-// If it is, it proceeds to compare the Name and Channel of the two
-// objects. The function returns true if the two objects are equal.
+// If it is, it proceeds to compare the Name, Channel and Channels of the two
+// objects, with the `channel::` prefix of the Name split into the Channel. The function returns true if the two objects are equal.
 // Otherwise, it returns false.
 //
 // This function is useful for comparing two condaSpell objects to determine if
@@ -65,14 +109,36 @@ func (c condaSpell) equals(t equatable) bool {
 	if err != nil {
 		return false
 	}
+	// Spells recorded before the channel was split from the name.
+	if split, err := c.withChannelSplit(); err == nil {
+		c = split
+	}
+	if split, err := o.withChannelSplit(); err == nil {
+		o = &split
+	}
 	return strings.Compare(c.Name, o.Name) == 0 &&
-		strings.Compare(c.Channel, o.Channel) == 0
+		strings.Compare(c.Channel, o.Channel) == 0 &&
+		slices.Equal(c.Channels, o.Channels)
+}
+
+// validate checks that the spell can be committed and saved.
+func (c condaSpell) validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("%w conda spell without a name.", ErrInvalidSpell)
+	}
+	for _, p := range c.Packages {
+		if p.Name == "" || p.Version == "" || p.Build == "" {
+			return fmt.Errorf("%w conda spell %s has an incomplete package: %v",
+				ErrInvalidSpell, c.Name, p)
+		}
+	}
+	return nil
 }
 
 // BulkSave implements Module.
 func (c *condaModule) BulkSave(config *Config) error {
-	for _, cs := range config.Conda {
-		if err := c.Save(cs); err != nil {
+	for i := range config.Conda {
+		if err := c.Save(&config.Conda[i]); err != nil {
 			return err
 		}
 	}
@@ -85,10 +151,12 @@ func (c *condaModule) BulkSave(config *Config) error {
 // Intended to be used by cobra.
 func (c *condaModule) cobraRun(config *Config) func(*cobra.Command, []string) {
 	return func(cmd *cobra.Command, args []string) {
-		channel, _ := cmd.Flags().GetString("channel")
+		channels, _ := cmd.Flags().GetStringSlice("channel")
+		priority, _ := cmd.Flags().GetString("channel-priority")
 		spell, err := c.bareRun(condaSpell{
-			Name:    args[0],
-			Channel: channel,
+			Name:            args[0],
+			Channels:        channels,
+			ChannelPriority: priority,
 		})
 		if err != nil {
 			logger.Get().Fatal(err)
@@ -124,7 +192,10 @@ func (c *condaModule) CliConfig(config *Config) *cobra.Command {
 		Run:     c.cobraRun(config),
 		Args:    c.cobraArgs(),
 	}
-	command.PersistentFlags().String("channel", "", "Conda channel to use.")
+	command.PersistentFlags().StringSlice("channel", nil,
+		"Conda channel to use, in order of priority. Overrides the channels of the project.")
+	command.PersistentFlags().String("channel-priority", "",
+		"Conda channel priority: strict, flexible or disabled. Overrides the priority of the project.")
 	return command
 }
 
@@ -134,6 +205,9 @@ func (c *condaModule) Commit(config *Config, result any) error {
 	if err != nil {
 		return ErrConverting
 	}
+	if err = newEntry.validate(); err != nil {
+		return err
+	}
 	if Contains(config.Conda, *newEntry) {
 		return ErrAlreadyPresent
 	}
@@ -142,7 +216,14 @@ func (c *condaModule) Commit(config *Config, result any) error {
 }
 
 func (c *condaModule) bareRun(p condaSpell) (condaSpell, error) {
-	if spell := cache.Retrieve(condaModuleName, p.Name); spell != nil {
+	p, err := p.withChannelSplit()
+	if err != nil {
+		return condaSpell{}, err
+	}
+	if err = p.validate(); err != nil {
+		return condaSpell{}, err
+	}
+	if spell := cache.Retrieve(condaModuleName, p.specification()); spell != nil {
 		newSpell, err := types.To[condaSpell](spell)
 		if err != nil {
 			logger.Get().Printf(`[conda/bareRun]: %v`, err)
@@ -150,57 +231,51 @@ func (c *condaModule) bareRun(p condaSpell) (condaSpell, error) {
 			return *newSpell, nil
 		}
 	}
-	condaBinary, err := condautils.DetectCondaBinary()
+	backend, err := newCondaBackend()
+	if err != nil {
+		return condaSpell{}, fmt.Errorf("bareRun, detecting conda backend: %v", err)
+	}
+	p, err = p.withProjectChannels()
 	if err != nil {
-		return condaSpell{}, nil
-	}
-	cmd, err := goconda.New(condaBinary, "", "").
-		Install(&goconda.PackageInfo{
-			PackageName: p.Name,
-			Channel:     p.Channel,
-		}).
-		DryRun().
-		Seal()
-	err = cmd.Run(&goconda.RunOptions{
-		Output: os.Stdout,
-	})
+		return condaSpell{}, err
+	}
+	packages, err := backend.solve(p)
 	if err != nil {
 		return condaSpell{}, err
 	}
-	_ = cache.Insert(condaModuleName, p.Name, p)
+	p.Packages = packages
+	_ = cache.Insert(condaModuleName, p.specification(), p)
 	return p, nil
 }
 
 // Save implements Module.
+//
+// The packages of the solution are downloaded exactly, and their URLs and
+// hashes are recorded in the spell. To persist them, Save should be called
+// with a pointer to the spell.
 func (c *condaModule) Save(anySpell any) error {
 	spell, err := types.To[condaSpell](anySpell)
 	if err != nil {
 		return ErrConverting
 	}
-	if cache.Retrieve(condaModuleName, spell.Name) != nil {
+	if err = spell.validate(); err != nil {
+		return err
+	}
+	if cache.Retrieve(condaModuleName+"save", spell.specification()) != nil {
 		return nil
 	}
 	project, err := project.ProjectPath()
 	if err != nil {
 		return err
 	}
-	condaBinary, err := condautils.DetectCondaBinary()
+	backend, err := newCondaBackend()
 	if err != nil {
-		return err
+		return fmt.Errorf("Save, detecting conda backend: %v", err)
 	}
 	downloadPath := path.Join(*project, condaModuleName)
-	cmd, err := goconda.
-		New(condaBinary, downloadPath, downloadPath).
-		Download(&goconda.PackageInfo{
-			PackageName: spell.Name,
-			Channel:     spell.Channel,
-		}, downloadPath).Seal()
-
-	err = cmd.Run(&goconda.RunOptions{
-		Output: os.Stdout,
-	})
+	err = backend.download(downloadPath, spell)
 	if err == nil {
-		_ = cache.Insert(aptModuleName, spell.Name, true)
+		_ = cache.Insert(condaModuleName+"save", spell.specification(), true)
 	}
 	return err
 }
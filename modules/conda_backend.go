@@ -0,0 +1,101 @@
+package modules
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	condautils "github.com/CREDOProject/go-conda/utils"
+)
+
+const (
+	condaBackendConda      = "conda"
+	condaBackendMamba      = "mamba"
+	condaBackendMicromamba = "micromamba"
+)
+
+// Backends tried, in order, when none is selected. The faster solvers come
+// first.
+var condaBackendsAuto = []string{
+	condaBackendMicromamba,
+	condaBackendMamba,
+	condaBackendConda,
+}
+
+// condaSettings is the project-level configuration of the condaModule.
+type condaSettings struct {
+	// Backend is the binary used, among conda, mamba and micromamba. When
+	// empty, the first one available is used. It can be overridden by the
+	// CREDO_CONDA_BACKEND environment variable.
+	Backend string `yaml:"backend,omitempty"`
+	// Channels are the channels used by the spells without their own, in
+	// order of priority.
+	Channels []string `yaml:"channels,omitempty"`
+	// ChannelPriority is the channel priority used by the spells without
+	// their own: strict, flexible or disabled.
+	ChannelPriority string `yaml:"channel_priority,omitempty"`
+}
+
+// condaBackend is the binary used by the condaModule to solve and download
+// packages. conda, mamba and micromamba share the same command line
+// interface and package cache layout, so that the choice of the backend is
+// transparent to the spell.
+type condaBackend struct {
+	name   string
+	binary string
+}
+
+// newCondaBackend returns the backend selected by the user or the project,
+// or the first one available.
+func newCondaBackend() (*condaBackend, error) {
+	backend := userSetting("CONDA_BACKEND")
+	if backend == "" {
+		backend = projectSettings.Conda.Backend
+	}
+	if backend != "" {
+		return lookupCondaBackend(backend)
+	}
+	for _, name := range condaBackendsAuto {
+		if found, err := lookupCondaBackend(name); err == nil {
+			return found, nil
+		}
+	}
+	return nil, fmt.Errorf("No conda backend found, tried: %s.",
+		strings.Join(condaBackendsAuto, ", "))
+}
+
+// lookupCondaBackend looks for the binary of the backend in the PATH.
+func lookupCondaBackend(name string) (*condaBackend, error) {
+	var binary string
+	var err error
+	switch name {
+	case condaBackendConda:
+		binary, err = condautils.DetectCondaBinary()
+	case condaBackendMamba, condaBackendMicromamba:
+		binary, err = exec.LookPath(name)
+	default:
+		return nil, fmt.Errorf("Unknown conda backend: %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s backend: %v", name, err)
+	}
+	return &condaBackend{name: name, binary: binary}, nil
+}
+
+// Hosts whose channel URLs are abbreviated to the channel name.
+var condaChannelHosts = []string{
+	"https://conda.anaconda.org/",
+	"https://repo.anaconda.com/",
+}
+
+// normalizeCondaChannel returns the channel name as reported by conda.
+// micromamba reports the URL of the channel subdirectory instead.
+func normalizeCondaChannel(channel string, subdir string) string {
+	for _, host := range condaChannelHosts {
+		if name, ok := strings.CutPrefix(channel, host); ok {
+			name = strings.TrimSuffix(name, "/")
+			return strings.TrimSuffix(name, "/"+subdir)
+		}
+	}
+	return channel
+}
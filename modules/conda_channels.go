@@ -0,0 +1,123 @@
+package modules
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Channel priority modes.
+//
+// https://docs.conda.io/projects/conda/en/latest/user-guide/tasks/manage-channels.html#strict-channel-priority
+const (
+	condaPriorityStrict   = "strict"
+	condaPriorityFlexible = "flexible"
+	condaPriorityDisabled = "disabled"
+)
+
+// splitCondaChannel splits a match specification of the form
+// `channel::name` into its channel and the rest of the specification.
+func splitCondaChannel(spec string) (string, string) {
+	if channel, name, ok := strings.Cut(spec, "::"); ok {
+		return channel, name
+	}
+	return "", spec
+}
+
+// withChannelSplit returns the spell with the `channel::` prefix of its name
+// moved to its channel, so that `bioconda::samtools` and samtools pinned to
+// bioconda are the same spell.
+func (s condaSpell) withChannelSplit() (condaSpell, error) {
+	channel, name := splitCondaChannel(s.Name)
+	if channel == "" {
+		return s, nil
+	}
+	if s.Channel != "" && s.Channel != channel {
+		return s, fmt.Errorf("%w conda spell %s is pinned to the channel %s.",
+			ErrInvalidSpell, s.Name, s.Channel)
+	}
+	s.Channel, s.Name = channel, name
+	return s, nil
+}
+
+// specification returns the match specification of the spell, with the
+// channel it is pinned to, if any.
+func (s condaSpell) specification() string {
+	if s.Channel == "" {
+		return s.Name
+	}
+	return s.Channel + "::" + s.Name
+}
+
+// channels returns the channels of the spell, in order of priority.
+// Spells without channels use the ones of the project, after the channel
+// of the spell, if any.
+func (s condaSpell) channels() []string {
+	if len(s.Channels) > 0 {
+		return s.Channels
+	}
+	channels := []string{}
+	if s.Channel != "" {
+		channels = append(channels, s.Channel)
+	}
+	for _, channel := range projectSettings.Conda.Channels {
+		if !slices.Contains(channels, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// channelPriority returns the channel priority of the spell, or the one of
+// the project.
+func (s condaSpell) channelPriority() string {
+	if s.ChannelPriority != "" {
+		return s.ChannelPriority
+	}
+	return projectSettings.Conda.ChannelPriority
+}
+
+// withProjectChannels returns the spell with the channels and the channel
+// priority actually used recorded in it, so that saving it reproduces the
+// same solve regardless of the project settings and of the host
+// configuration.
+func (s condaSpell) withProjectChannels() (condaSpell, error) {
+	s.Channels = s.channels()
+	s.ChannelPriority = s.channelPriority()
+	switch s.ChannelPriority {
+	case "", condaPriorityStrict, condaPriorityFlexible, condaPriorityDisabled:
+		return s, nil
+	}
+	return s, fmt.Errorf("Unknown conda channel priority: %s", s.ChannelPriority)
+}
+
+// channelArgs returns the arguments selecting the channels of the spell,
+// which override the ones configured on the host, and its channel priority.
+func (s condaSpell) channelArgs() []string {
+	args := []string{}
+	if channels := s.channels(); len(channels) > 0 {
+		args = append(args, "--override-channels")
+		for _, channel := range channels {
+			args = append(args, "--channel", channel)
+		}
+	}
+	switch s.channelPriority() {
+	case condaPriorityStrict:
+		args = append(args, "--strict-channel-priority")
+	case condaPriorityDisabled:
+		args = append(args, "--no-channel-priority")
+	}
+	return args
+}
+
+// channelEnv returns the environment variables selecting the channel
+// priority of the spell, for the modes without a command line flag.
+func (s condaSpell) channelEnv() []string {
+	if s.channelPriority() != condaPriorityFlexible {
+		return nil
+	}
+	return []string{
+		"CONDA_CHANNEL_PRIORITY=" + condaPriorityFlexible,
+		"MAMBA_CHANNEL_PRIORITY=" + condaPriorityFlexible,
+	}
+}
@@ -0,0 +1,245 @@
+package modules
+
+import (
+	"credo/project"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Registers the conda importers and exporter.
+func init() {
+	registerImporter("environment*.yml", importCondaEnvironment)
+	registerImporter("environment*.yaml", importCondaEnvironment)
+	registerExporter("conda", exportCondaEnvironment)
+}
+
+// condaEnvironment is a conda environment file.
+//
+// https://docs.conda.io/projects/conda/en/latest/user-guide/tasks/manage-environments.html#create-env-file-manually
+type condaEnvironment struct {
+	Name         string   `yaml:"name,omitempty"`
+	Channels     []string `yaml:"channels,omitempty"`
+	Dependencies []any    `yaml:"dependencies"`
+}
+
+// condaNoDefaults is the entry of the channels of an environment file which
+// excludes the defaults channel.
+const condaNoDefaults = "nodefaults"
+
+// normalizeCondaSpec returns a conda match specification, with the optional
+// `channel::` prefix, as a single word.
+// The space separated form `name version build` is converted to the
+// `name=version=build` form.
+func normalizeCondaSpec(spec string) string {
+	fields := strings.Fields(spec)
+	if len(fields) > 1 && !strings.ContainsAny(fields[1][:1], "=<>!~") {
+		return strings.Join(fields, "=")
+	}
+	return strings.Join(fields, "")
+}
+
+// parseCondaEnvironment returns the conda spells and the pip requirement
+// lines of an environment file.
+// Every spell uses the channels of the file. The nodefaults entry, which is
+// not a channel, is dropped: the channels of a spell always override the
+// ones of the host, defaults included.
+func parseCondaEnvironment(content []byte) ([]condaSpell, []string, error) {
+	var environment condaEnvironment
+	if err := yaml.Unmarshal(content, &environment); err != nil {
+		return nil, nil, err
+	}
+	environment.Channels = slices.DeleteFunc(environment.Channels,
+		func(channel string) bool { return channel == condaNoDefaults })
+	if len(environment.Channels) == 0 {
+		environment.Channels = nil
+	}
+	spells := []condaSpell{}
+	pipLines := []string{}
+	for _, dependency := range environment.Dependencies {
+		switch d := dependency.(type) {
+		case string:
+			spell, err := condaSpell{
+				Name:     normalizeCondaSpec(d),
+				Channels: environment.Channels,
+			}.withChannelSplit()
+			if err != nil {
+				return nil, nil, err
+			}
+			spells = append(spells, spell)
+		case map[string]any:
+			entries, ok := d["pip"].([]any)
+			if !ok {
+				return nil, nil, fmt.Errorf("Unknown dependency: %v", d)
+			}
+			for _, entry := range entries {
+				pipLines = append(pipLines, fmt.Sprint(entry))
+			}
+		default:
+			return nil, nil, fmt.Errorf("Unknown dependency: %v", d)
+		}
+	}
+	return spells, pipLines, nil
+}
+
+// importCondaEnvironment imports the conda and pip dependencies of a conda
+// environment file.
+func importCondaEnvironment(config *Config, file string, _ importOptions) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	spells, pipLines, err := parseCondaEnvironment(content)
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	c := &condaModule{}
+	for _, s := range spells {
+		spell, err := c.bareRun(s)
+		if err != nil {
+			return err
+		}
+		if err = c.Commit(config, spell); err != nil && err != ErrAlreadyPresent {
+			return err
+		}
+	}
+	if len(pipLines) == 0 {
+		return nil
+	}
+	absolute, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	root, err := pipProjectRoot()
+	if err != nil {
+		return err
+	}
+	requirements := &pipRequirements{root: root}
+	err = requirements.parseLines(file, pipLines, filepath.Dir(absolute),
+		false, map[string]struct{}{absolute: {}})
+	if err != nil {
+		return err
+	}
+	return importPipRequirements(config, file, requirements)
+}
+
+// condaPins are the pins of the packages solved for the conda spells, by
+// package. The spells are solved independently, so that two of them may pin
+// a package differently, which cannot be installed together.
+type condaPins map[string]struct {
+	spell string
+	pin   string
+}
+
+// add records the pin of the package by the spell. It reports whether the
+// package was not pinned yet, and fails when another spell pinned it
+// differently.
+func (c condaPins) add(key string, spell string, pin string) (bool, error) {
+	previous, ok := c[key]
+	if !ok {
+		c[key] = struct {
+			spell string
+			pin   string
+		}{spell, pin}
+		return true, nil
+	}
+	if previous.pin != pin {
+		return false, fmt.Errorf("conda spells %s and %s pin %s and %s.",
+			previous.spell, spell, previous.pin, pin)
+	}
+	return false, nil
+}
+
+// condaEnvironmentFrom returns the environment described by the conda and
+// pip spells of the configuration.
+//
+// Solved conda spells are pinned to the version and build of every package
+// of their solution, which must not conflict. Saved pip spells are pinned to
+// the versions of their artifacts.
+func condaEnvironmentFrom(config *Config) (*condaEnvironment, error) {
+	environment := &condaEnvironment{Dependencies: []any{}}
+	project, err := project.ProjectPath()
+	if err != nil {
+		return nil, err
+	}
+	environment.Name = filepath.Base(filepath.Dir(*project))
+	hasPip := false
+	pins := condaPins{}
+	addChannel := func(channel string) {
+		if !slices.Contains(environment.Channels, channel) {
+			environment.Channels = append(environment.Channels, channel)
+		}
+	}
+	for _, spell := range config.Conda {
+		for _, channel := range spell.channels() {
+			addChannel(channel)
+		}
+		if len(spell.Packages) == 0 {
+			hasPip = hasPip || spell.Name == "pip"
+			environment.Dependencies = append(environment.Dependencies,
+				spell.specification())
+			continue
+		}
+		for _, p := range spell.Packages {
+			addChannel(p.Channel)
+			specification := fmt.Sprintf("%s=%s=%s", p.Name, p.Version, p.Build)
+			added, err := pins.add(p.Name, spell.Name, specification)
+			if err != nil {
+				return nil, err
+			}
+			if added {
+				environment.Dependencies = append(environment.Dependencies,
+					specification)
+			}
+			hasPip = hasPip || p.Name == "pip"
+		}
+	}
+	if len(config.Pip) == 0 {
+		return environment, nil
+	}
+	if !hasPip {
+		environment.Dependencies = append(environment.Dependencies, "pip")
+	}
+	requirements := []string{}
+	if pins, err := pipPins(config.Pip); err == nil {
+		for _, pin := range pins {
+			pin.hashes = nil
+			requirements = append(requirements, strings.TrimSpace(pin.String()))
+		}
+	} else {
+		// The pip spells have not been saved yet.
+		for _, spell := range config.Pip {
+			requirements = append(requirements, spell.requirement())
+		}
+	}
+	environment.Dependencies = append(environment.Dependencies,
+		map[string]any{"pip": requirements})
+	return environment, nil
+}
+
+// exportCondaEnvironment writes a conda environment file from the conda and
+// pip spells of the configuration.
+func exportCondaEnvironment(config *Config, output string) error {
+	environment, err := condaEnvironmentFrom(config)
+	if err != nil {
+		return err
+	}
+	writer, err := exportWriter(output)
+	if err != nil {
+		return err
+	}
+	encoder := yaml.NewEncoder(writer)
+	encoder.SetIndent(2)
+	err = encoder.Encode(environment)
+	if err == nil {
+		err = encoder.Close()
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package modules
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Registers the conda explicit specification exporter.
+func init() { registerExporter("conda-lock", exportCondaLock) }
+
+// Subdirectory of the packages which are not platform specific.
+const condaNoarchSubdir = "noarch"
+
+// condaLocks returns the explicit specifications of the saved conda spells,
+// indexed by platform. Platform independent packages are part of every
+// platform. Two spells pinning a package of a platform differently conflict.
+//
+// https://docs.conda.io/projects/conda/en/latest/user-guide/tasks/manage-environments.html#building-identical-conda-environments
+func condaLocks(spells []condaSpell) (map[string]string, error) {
+	type pinned struct {
+		spell string
+		condaPackage
+	}
+	platforms := map[string][]pinned{}
+	noarch := []pinned{}
+	for _, spell := range spells {
+		if len(spell.Packages) == 0 {
+			return nil, fmt.Errorf("conda spell %s has no solution.", spell.Name)
+		}
+		for _, p := range spell.Packages {
+			if p.URL == "" {
+				return nil, fmt.Errorf(
+					"conda spell %s has not been saved, run `credo save` first.",
+					spell.Name)
+			}
+			if p.Subdir == condaNoarchSubdir {
+				noarch = append(noarch, pinned{spell.Name, p})
+				continue
+			}
+			platforms[p.Subdir] = append(platforms[p.Subdir], pinned{spell.Name, p})
+		}
+	}
+	if len(platforms) == 0 && len(noarch) > 0 {
+		platforms[condaNoarchSubdir] = []pinned{}
+	}
+	locks := map[string]string{}
+	for platform, packages := range platforms {
+		lines := []string{}
+		pins := condaPins{}
+		for _, p := range append(packages, noarch...) {
+			line := p.URL
+			if p.MD5 != "" {
+				line += "#" + p.MD5
+			} else if p.SHA256 != "" {
+				line += "#sha256:" + p.SHA256
+			}
+			added, err := pins.add(p.Name, p.spell, p.URL)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", platform, err)
+			}
+			if added {
+				lines = append(lines, line)
+			}
+		}
+		locks[platform] = fmt.Sprintf(
+			"# This file is automatically generated by CREDO.\n"+
+				"# platform: %s\n@EXPLICIT\n%s\n",
+			platform, strings.Join(lines, "\n"))
+	}
+	return locks, nil
+}
+
+// condaLockOutput returns the file the explicit specification of platform is
+// written to. The platform is inserted before the extension of output.
+func condaLockOutput(output string, platform string) string {
+	extension := filepath.Ext(output)
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(output, extension),
+		platform, extension)
+}
+
+// exportCondaLock writes an explicit specification file, which conda can
+// install without solving, for every platform of the saved conda spells.
+// With several platforms, output is required and the platform is inserted in
+// the name of each file.
+func exportCondaLock(config *Config, output string) error {
+	locks, err := condaLocks(config.Conda)
+	if err != nil {
+		return err
+	}
+	if len(locks) > 1 && output == "" {
+		return fmt.Errorf("The conda spells target %d platforms, an output file is required.",
+			len(locks))
+	}
+	for platform, lock := range locks {
+		file := output
+		if len(locks) > 1 {
+			file = condaLockOutput(output, platform)
+		}
+		writer, err := exportWriter(file)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprint(writer, lock)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+)
+
+// condaPackage records a package of a solved conda environment.
+type condaPackage struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	Build   string `yaml:"build"`
+	Channel string `yaml:"channel"`
+	Subdir  string `yaml:"subdir"`
+	URL     string `yaml:"url,omitempty"`
+	MD5     string `yaml:"md5,omitempty"`
+	SHA256  string `yaml:"sha256,omitempty"`
+}
+
+// distName returns the canonical name of the package, which is also the name
+// of its directory in a package cache.
+func (p condaPackage) distName() string {
+	return fmt.Sprintf("%s-%s-%s", p.Name, p.Version, p.Build)
+}
+
+// specification returns the match specification selecting exactly the
+// package.
+func (p condaPackage) specification() string {
+	return fmt.Sprintf("%s::%s==%s=%s", p.Channel, p.Name, p.Version, p.Build)
+}
+
+// condaSolution is the relevant part of the JSON output of a conda dry-run.
+type condaSolution struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Error   string `json:"error"`
+	Actions struct {
+		Link []struct {
+			Name        string `json:"name"`
+			Version     string `json:"version"`
+			BuildString string `json:"build_string"`
+			Channel     string `json:"channel"`
+			Platform    string `json:"platform"`
+			Subdir      string `json:"subdir"`
+		} `json:"LINK"`
+	} `json:"actions"`
+}
+
+// parseCondaSolution parses the JSON output of a conda dry-run into the list
+// of the packages of the solution, sorted by name.
+func parseCondaSolution(output []byte) ([]condaPackage, error) {
+	var solution condaSolution
+	if err := json.Unmarshal(output, &solution); err != nil {
+		return nil, fmt.Errorf("parsing conda solution: %v", err)
+	}
+	if !solution.Success {
+		if solution.Message != "" {
+			return nil, errors.New(solution.Message)
+		}
+		return nil, fmt.Errorf("conda solution failed: %s", solution.Error)
+	}
+	packages := []condaPackage{}
+	for _, link := range solution.Actions.Link {
+		// conda reports the subdirectory as platform, micromamba as subdir.
+		subdir := link.Subdir
+		if subdir == "" {
+			subdir = link.Platform
+		}
+		packages = append(packages, condaPackage{
+			Name:    link.Name,
+			Version: link.Version,
+			Build:   link.BuildString,
+			Channel: normalizeCondaChannel(link.Channel, subdir),
+			Subdir:  subdir,
+		})
+	}
+	slices.SortFunc(packages, func(a, b condaPackage) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return packages, nil
+}
+
+// solve solves a new environment containing the package and returns every
+// package of the solution.
+func (b *condaBackend) solve(p condaSpell) ([]condaPackage, error) {
+	// The prefix is never created, a new environment is used so that the
+	// solution is complete rather than relative to an existing environment.
+	prefix := path.Join(os.TempDir(), "credo-conda-solve")
+	args := []string{"create", "--dry-run", "--json", "--prefix", prefix,
+		p.specification()}
+	cmd := exec.Command(b.binary, append(args, p.channelArgs()...)...)
+	cmd.Env = append(os.Environ(), p.channelEnv()...)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	packages, parseErr := parseCondaSolution(output)
+	if parseErr != nil {
+		if err != nil {
+			return nil, fmt.Errorf("solving %s: %v, %v", p.Name, err, parseErr)
+		}
+		return nil, fmt.Errorf("solving %s: %v", p.Name, parseErr)
+	}
+	return packages, err
+}
+
+// download downloads the packages of the solution of the spell into
+// downloadPath, used as a package cache, and records their URLs and hashes.
+// Spells without a solution are solved again while downloading.
+func (b *condaBackend) download(downloadPath string, spell *condaSpell) error {
+	prefix, err := os.MkdirTemp("", "credo-conda-download-")
+	if err != nil {
+		return err
+	}
+	// The prefix must not exist for conda to create it.
+	if err = os.Remove(prefix); err != nil {
+		return err
+	}
+	defer os.RemoveAll(prefix)
+	args := []string{"create", "--download-only", "--yes", "--prefix", prefix}
+	if len(spell.Packages) == 0 {
+		args = append(args, spell.specification())
+	}
+	for _, p := range spell.Packages {
+		args = append(args, p.specification())
+	}
+	cmd := exec.Command(b.binary, append(args, spell.channelArgs()...)...)
+	cmd.Env = append(os.Environ(), spell.channelEnv()...)
+	cmd.Env = append(cmd.Env, "CONDA_PKGS_DIRS="+downloadPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		return err
+	}
+	for i := range spell.Packages {
+		err = spell.Packages[i].readRecord(downloadPath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// install installs the packages of an explicit specification into the
+// environment at prefix, creating it if needed, using only the packages of
+// the package cache at cachePath.
+func (b *condaBackend) install(cachePath string, prefix string, explicit string) error {
+	file, err := os.CreateTemp("", "credo-conda-explicit-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(explicit)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	action := "install"
+	if _, err = os.Stat(prefix); errors.Is(err, os.ErrNotExist) {
+		action = "create"
+	}
+	cmd := exec.Command(b.binary, action, "--offline", "--yes", "--prefix",
+		prefix, "--file", file.Name())
+	cmd.Env = append(os.Environ(), "CONDA_PKGS_DIRS="+cachePath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// readRecord reads the URL and the hashes of the package from its repodata
+// record in the package cache.
+func (p *condaPackage) readRecord(cachePath string) error {
+	content, err := os.ReadFile(path.Join(cachePath, p.distName(), "info",
+		"repodata_record.json"))
+	if err != nil {
+		return fmt.Errorf("reading the record of %s: %v", p.distName(), err)
+	}
+	var record struct {
+		URL    string `json:"url"`
+		MD5    string `json:"md5"`
+		SHA256 string `json:"sha256"`
+	}
+	if err = json.Unmarshal(content, &record); err != nil {
+		return fmt.Errorf("reading the record of %s: %v", p.distName(), err)
+	}
+	p.URL, p.MD5, p.SHA256 = record.URL, record.MD5, record.SHA256
+	return nil
+}
@@ -0,0 +1,246 @@
+package modules
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseCondaSolution(t *testing.T) {
+	output := []byte(`{
+  "success": true,
+  "actions": {
+    "FETCH": [],
+    "PREFIX": "/tmp/credo-conda-solve",
+    "LINK": [
+      {
+        "base_url": "https://conda.anaconda.org/conda-forge",
+        "build_number": 0,
+        "build_string": "h4bc722e_7",
+        "channel": "conda-forge",
+        "dist_name": "zlib-1.3.1-h4bc722e_7",
+        "name": "zlib",
+        "platform": "linux-64",
+        "version": "1.3.1"
+      },
+      {
+        "base_url": "https://conda.anaconda.org/bioconda",
+        "build_number": 0,
+        "build_string": "h50ea8bc_0",
+        "channel": "bioconda",
+        "dist_name": "samtools-1.19-h50ea8bc_0",
+        "name": "samtools",
+        "platform": "linux-64",
+        "version": "1.19"
+      }
+    ]
+  }
+}`)
+	expected := []condaPackage{
+		{Name: "samtools", Version: "1.19", Build: "h50ea8bc_0",
+			Channel: "bioconda", Subdir: "linux-64"},
+		{Name: "zlib", Version: "1.3.1", Build: "h4bc722e_7",
+			Channel: "conda-forge", Subdir: "linux-64"},
+	}
+	packages, err := parseCondaSolution(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(packages, expected) {
+		t.Errorf("parseCondaSolution() = %v, want %v", packages, expected)
+	}
+	_, err = parseCondaSolution([]byte(`{"success": false, "message": "PackagesNotFoundError"}`))
+	if err == nil || err.Error() != "PackagesNotFoundError" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_parseCondaEnvironment(t *testing.T) {
+	content := []byte(`name: rnaseq
+channels:
+  - conda-forge
+  - bioconda
+  - nodefaults
+dependencies:
+  - python=3.11
+  - bioconda::samtools 1.19 h50ea8bc_0
+  - numpy >=1.26
+  - pip
+  - pip:
+    - requests==2.31.0
+    - -r requirements.txt
+`)
+	spells, pipLines, err := parseCondaEnvironment(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channels := []string{"conda-forge", "bioconda"}
+	expected := []condaSpell{
+		{Name: "python=3.11", Channels: channels},
+		{Name: "samtools=1.19=h50ea8bc_0", Channel: "bioconda", Channels: channels},
+		{Name: "numpy>=1.26", Channels: channels},
+		{Name: "pip", Channels: channels},
+	}
+	if !reflect.DeepEqual(spells, expected) {
+		t.Errorf("spells = %v, want %v", spells, expected)
+	}
+	expectedPip := []string{"requests==2.31.0", "-r requirements.txt"}
+	if !reflect.DeepEqual(pipLines, expectedPip) {
+		t.Errorf("pip = %v, want %v", pipLines, expectedPip)
+	}
+}
+
+func Test_condaPins(t *testing.T) {
+	pins := condaPins{}
+	for _, pin := range []struct {
+		spell, pin string
+		added      bool
+	}{
+		{"python=3.11", "python=3.11.8=hab00c5b_0", true},
+		{"numpy", "python=3.11.8=hab00c5b_0", false},
+	} {
+		added, err := pins.add("python", pin.spell, pin.pin)
+		if err != nil || added != pin.added {
+			t.Errorf("add(%s) = %v, %v, want %v", pin.pin, added, err, pin.added)
+		}
+	}
+	_, err := pins.add("python", "python=3.12", "python=3.12.2=hab00c5b_0")
+	if err == nil || !strings.Contains(err.Error(), "python=3.11 and python=3.12") {
+		t.Errorf("add() = %v, want a conflict", err)
+	}
+}
+
+func Test_condaLocks(t *testing.T) {
+	spells := []condaSpell{{
+		Name: "samtools",
+		Packages: []condaPackage{
+			{Name: "samtools", Subdir: "linux-64", MD5: "aa",
+				URL: "https://conda.anaconda.org/bioconda/linux-64/samtools-1.19-h50ea8bc_0.tar.bz2"},
+			{Name: "tzdata", Subdir: "noarch", MD5: "bb",
+				URL: "https://conda.anaconda.org/conda-forge/noarch/tzdata-2024a-h0c530f3_0.conda"},
+		},
+	}}
+	locks, err := condaLocks(spells)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"linux-64": `# This file is automatically generated by CREDO.
+# platform: linux-64
+@EXPLICIT
+https://conda.anaconda.org/bioconda/linux-64/samtools-1.19-h50ea8bc_0.tar.bz2#aa
+https://conda.anaconda.org/conda-forge/noarch/tzdata-2024a-h0c530f3_0.conda#bb
+`}
+	if !reflect.DeepEqual(locks, expected) {
+		t.Errorf("condaLocks() = %v, want %v", locks, expected)
+	}
+	conflicting := append(spells, condaSpell{
+		Name: "tzdata=2023c",
+		Packages: []condaPackage{{Name: "tzdata", Subdir: "noarch", MD5: "cc",
+			URL: "https://conda.anaconda.org/conda-forge/noarch/tzdata-2023c-h71feb2d_0.conda"}},
+	})
+	if _, err = condaLocks(conflicting); err == nil ||
+		!strings.Contains(err.Error(), "samtools and tzdata=2023c") {
+		t.Errorf("condaLocks() = %v, want a conflict", err)
+	}
+	spells[0].Packages[0].URL = ""
+	if _, err = condaLocks(spells); err == nil {
+		t.Error("expected error.")
+	}
+}
+
+func Test_normalizeCondaChannel(t *testing.T) {
+	tests := []struct {
+		channel  string
+		expected string
+	}{
+		{"conda-forge", "conda-forge"},
+		{"https://conda.anaconda.org/bioconda/linux-64", "bioconda"},
+		{"https://repo.anaconda.com/pkgs/main/linux-64/", "pkgs/main"},
+		{"https://example.org/channel/linux-64", "https://example.org/channel/linux-64"},
+	}
+	for _, test := range tests {
+		if got := normalizeCondaChannel(test.channel, "linux-64"); got != test.expected {
+			t.Errorf("normalizeCondaChannel(%s) = %s, want %s", test.channel,
+				got, test.expected)
+		}
+	}
+}
+
+func Test_condaSpellValidate(t *testing.T) {
+	config := &Config{}
+	err := (&condaModule{}).Commit(config, condaSpell{Name: " "})
+	if !errors.Is(err, ErrInvalidSpell) || len(config.Conda) != 0 {
+		t.Errorf("expected invalid spell, got %v", err)
+	}
+	spell := condaSpell{
+		Name:     "samtools",
+		Packages: []condaPackage{{Name: "samtools", Version: "1.19"}},
+	}
+	if err = spell.validate(); !errors.Is(err, ErrInvalidSpell) {
+		t.Errorf("expected invalid spell, got %v", err)
+	}
+	spell.Packages[0].Build = "h50ea8bc_0"
+	if err = spell.validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_condaSpellWithChannelSplit(t *testing.T) {
+	spell, err := condaSpell{Name: "bioconda::samtools=1.19"}.withChannelSplit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := condaSpell{Name: "samtools=1.19", Channel: "bioconda"}
+	if !reflect.DeepEqual(spell, expected) {
+		t.Errorf("withChannelSplit() = %v, want %v", spell, expected)
+	}
+	if spell.specification() != "bioconda::samtools=1.19" {
+		t.Errorf("specification() = %s", spell.specification())
+	}
+	if !(condaSpell{Name: "bioconda::samtools=1.19"}).equals(expected) {
+		t.Errorf("bioconda::samtools=1.19 should equal its split spell")
+	}
+	if (condaSpell{Name: "conda-forge::samtools=1.19"}).equals(expected) {
+		t.Errorf("conda-forge::samtools=1.19 should not equal %v", expected)
+	}
+	_, err = condaSpell{Name: "bioconda::samtools", Channel: "conda-forge"}.
+		withChannelSplit()
+	if !errors.Is(err, ErrInvalidSpell) {
+		t.Errorf("withChannelSplit() error = %v, want %v", err, ErrInvalidSpell)
+	}
+}
+
+func Test_condaBackendInstall(t *testing.T) {
+	directory := t.TempDir()
+	log := filepath.Join(directory, "log")
+	binary := filepath.Join(directory, "conda")
+	script := "#!/bin/sh\necho \"$CONDA_PKGS_DIRS $@\" >> " + log + "\n"
+	if err := os.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	backend := &condaBackend{name: condaBackendConda, binary: binary}
+	prefix := filepath.Join(directory, "condaenv")
+	if err := backend.install("pkgs", prefix, "@EXPLICIT\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(prefix, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.install("pkgs", prefix, "@EXPLICIT\n"); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	for i, action := range []string{"create", "install"} {
+		expected := "pkgs " + action + " --offline --yes --prefix " + prefix + " --file "
+		if i >= len(lines) || !strings.HasPrefix(lines[i], expected) {
+			t.Errorf("ran %q, want %q", lines, expected)
+		}
+	}
+}
@@ -2,9 +2,17 @@ package modules
 
 // Application configuration.
 type Config struct {
-	Git   []gitSpell   `yaml:"git,omitempty"`
-	Pip   []pipSpell   `yaml:"pip,omitempty"`
-	Apt   []aptSpell   `yaml:"apt,omitempty"`
-	Conda []condaSpell `yaml:"conda,omitempty"`
-	Cran  []cranSpell  `yaml:"cran,omitempty"`
+	Settings Settings     `yaml:"settings,omitempty"`
+	Git      []gitSpell   `yaml:"git,omitempty"`
+	Pip      []pipSpell   `yaml:"pip,omitempty"`
+	Apt      []aptSpell   `yaml:"apt,omitempty"`
+	Conda    []condaSpell `yaml:"conda,omitempty"`
+	Dnf      []dnfSpell   `yaml:"dnf,omitempty"`
+	Apk      []apkSpell   `yaml:"apk,omitempty"`
+	Spack    []spackSpell `yaml:"spack,omitempty"`
+	URL      []urlSpell   `yaml:"url,omitempty"`
+	Data     []dataSpell  `yaml:"data,omitempty"`
+	Cpan     []cpanSpell  `yaml:"cpan,omitempty"`
+	Maven    []mavenSpell `yaml:"maven,omitempty"`
+	Cran     []cranSpell  `yaml:"cran,omitempty"`
 }
@@ -0,0 +1,329 @@
+package modules
+
+import (
+	"credo/cache"
+	"credo/logger"
+	"credo/project"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+
+	"github.com/CREDOProject/sharedutils/types"
+	"github.com/spf13/cobra"
+)
+
+const cpanModuleName = "cpan"
+
+const cpanModuleShort = "Retrieves a CPAN distribution and its dependencies."
+
+const cpanModuleExample = `
+Install the distribution of a module:
+	credo cpan Bio::Perl
+
+Install a given version of the distribution of a module:
+	credo cpan Bio::Perl@1.7.8
+`
+
+const cpanModuleLong = `Retrieves a CPAN distribution and its dependencies.
+
+CPAN metadata does not record the system packages a distribution needs to be
+built, such as the headers of the libraries it links. They are listed, by
+distribution and package manager, in the settings of the credospell
+configuration, and recorded as external dependencies of the spells:
+
+	settings:
+	  cpan:
+	    system_requirements:
+	      XML-LibXML:
+	        apt: [libxml2-dev]
+	        dnf: [libxml2-devel]
+`
+
+// Directory of the local::lib the distributions are installed into, in the
+// project.
+const cpanLibraryDirectory = "perl5"
+
+// cpanSettings is the project-level configuration of the cpanModule.
+type cpanSettings struct {
+	// SystemRequirements are the system packages required to build the
+	// distributions, such as the headers of the libraries they link, by
+	// distribution and package manager. CPAN metadata does not record them.
+	SystemRequirements map[string]map[string][]string `yaml:"system_requirements,omitempty"`
+}
+
+// Registers the cpanModule.
+func init() { Register(cpanModuleName, func() Module { return &cpanModule{} }) }
+
+// cpanModule is used to manage the cpan scope in the credospell
+// configuration.
+type cpanModule struct{}
+
+type cpanSpell struct {
+	// Module is the module requested, such as Bio::Perl.
+	Module       string `yaml:"module"`
+	Distribution string `yaml:"distribution,omitempty"`
+	Version      string `yaml:"version,omitempty"`
+	// Path is the path of the distribution file in the authors/id directory
+	// of CPAN, such as C/CJ/CJFIELDS/BioPerl-1.7.8.tar.gz.
+	Path   string `yaml:"path,omitempty"`
+	SHA256 string `yaml:"sha256,omitempty"`
+	// Dependencies are the distributions required, in installation order.
+	Dependencies         []cpanSpell `yaml:"dependencies,omitempty"`
+	ExternalDependencies Config      `yaml:"external_dependencies,omitempty"`
+}
+
+// Function used to check if two cpanSpell objects are equal.
+// It takes in an equatable interface as a parameter and returns a boolean
+// value indicating whether the two objects are equal or not.
+// The function first checks if the input parameter t is of type cpanSpell.
+//
+// If it is, it proceeds to compare the Distribution and Path of the two
+// objects and all its other Dependencies.
+// The function returns true if the two objects are equal.
+// Otherwise, it returns false.
+func (c cpanSpell) equals(t equatable) bool {
+	o, err := types.To[cpanSpell](t)
+	if err != nil {
+		return false
+	}
+	equality := o.Distribution == c.Distribution && o.Path == c.Path &&
+		len(o.Dependencies) == len(c.Dependencies)
+	if !equality {
+		return false
+	}
+	for i := range o.Dependencies {
+		equality = equality &&
+			o.Dependencies[i].equals(c.Dependencies[i])
+	}
+	return equality
+}
+
+// closure returns the dependencies of the spell followed by the spell itself.
+func (c cpanSpell) closure() []cpanSpell {
+	return append(slices.Clone(c.Dependencies), c)
+}
+
+// cpanMirror returns the directory of the local mirror in the project.
+func cpanMirror() (string, error) {
+	project, err := project.ProjectPath()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(*project, cpanModuleName), nil
+}
+
+// BulkSave implements Module.
+func (m *cpanModule) BulkSave(config *Config) error {
+	for _, cs := range config.Cpan {
+		err := m.Save(cs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CliConfig implements Module.
+func (m *cpanModule) CliConfig(config *Config) *cobra.Command {
+	return &cobra.Command{
+		Args:    m.cobraArgs(),
+		Example: cpanModuleExample,
+		Long:    cpanModuleLong,
+		Run:     m.cobraRun(config),
+		Short:   cpanModuleShort,
+		Use:     cpanModuleName,
+	}
+}
+
+// Function used to validate the arguments passed to the cpan command.
+// If no arguments are passed, it returns an error.
+// Otherwise it returns nil.
+//
+// Intended to be used by cobra.
+func (m *cpanModule) cobraArgs() func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return fmt.Errorf("%s module requires at least one argument.",
+				cpanModuleName)
+		}
+		return nil
+	}
+}
+
+// Function used to run the module from the command line.
+// It serves as an entry point to the bare run of the cpanModule.
+//
+// Intended to be used by cobra.
+func (m *cpanModule) cobraRun(config *Config) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, args []string) {
+		spell, err := m.bareRun(cpanSpell{
+			Module: args[0],
+		}, config)
+		if err != nil {
+			logger.Get().Fatal(err)
+		}
+		err = m.Commit(config, spell)
+		if err != nil && err != ErrAlreadyPresent {
+			logger.Get().Fatal(err)
+		}
+	}
+}
+
+// installApt installs perl and cpanm with the system package manager.
+func (m *cpanModule) installApt(config *Config) error {
+	if _, ok := Modules["apt"]; !ok {
+		return installDnf(config, []string{"perl", "perl-App-cpanminus", "make", "gcc"})
+	}
+	apt := aptModule{}
+	packages := []string{"perl", "cpanminus", "build-essential"}
+	for _, v := range packages {
+		spell, err := apt.bareRun(aptSpell{Name: v})
+		if err != nil {
+			return fmt.Errorf("InstallApt error barerun: %v", err)
+		}
+		if err = apt.Commit(config, spell); err != nil && err != ErrAlreadyPresent {
+			return fmt.Errorf("InstallApt error commiting: %v", err)
+		}
+		if err = apt.Save(spell); err != nil {
+			return fmt.Errorf("InstallApt error saving: %v", err)
+		}
+		if err = apt.Apply(spell); err != nil {
+			return fmt.Errorf("InstallApt error applying: %v", err)
+		}
+	}
+	return nil
+}
+
+// bareRun resolves the distribution of the module and the closure of its
+// dependencies with MetaCPAN. The system packages the distributions require,
+// listed in the settings of the project, are recorded as external
+// dependencies.
+func (m *cpanModule) bareRun(s cpanSpell, config *Config) (cpanSpell, error) {
+	if spell := cache.Retrieve(cpanModuleName+"bare", s.Module); spell != nil {
+		newSpell, err := types.To[cpanSpell](spell)
+		if err == nil {
+			return *newSpell, nil
+		}
+	}
+	if err := m.installApt(config); err != nil {
+		logger.Get().Printf("[cpan/bareRun]: %v", err)
+	}
+	resolver := newCpanResolver()
+	root, err := resolver.resolve(cpanModuleRequirement(s.Module))
+	if err != nil {
+		return cpanSpell{}, err
+	}
+	if root == nil {
+		return cpanSpell{}, fmt.Errorf("%s is provided by perl itself.", s.Module)
+	}
+	spell := *root
+	spell.Module = s.Module
+	spell.Dependencies = resolver.order[:len(resolver.order)-1]
+	spell.ExternalDependencies = s.ExternalDependencies
+	for _, dist := range spell.closure() {
+		recordSystemPackages(&spell.ExternalDependencies,
+			projectSettings.Cpan.SystemRequirements[dist.Distribution])
+	}
+	_ = cache.Insert(cpanModuleName+"bare", s.Module, spell)
+	return spell, nil
+}
+
+// Commit implements Module.
+func (*cpanModule) Commit(config *Config, result any) error {
+	newEntry, err := types.To[cpanSpell](result)
+	if err != nil {
+		return ErrConverting
+	}
+	if Contains(config.Cpan, *newEntry) {
+		return ErrAlreadyPresent
+	}
+	config.Cpan = append(config.Cpan, *newEntry)
+	return nil
+}
+
+// Save implements Module. The distribution files of the closure are
+// downloaded into the local mirror, whose package index is rewritten.
+func (*cpanModule) Save(anySpell any) error {
+	spell, err := types.To[cpanSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	err = DeepSave(&spell.ExternalDependencies)
+	if err != nil {
+		return err
+	}
+	mirror, err := cpanMirror()
+	if err != nil {
+		return err
+	}
+	for _, s := range spell.closure() {
+		if cache.Retrieve(cpanModuleName+"save", s.Path) != nil {
+			continue
+		}
+		file := path.Join(mirror, "authors", "id", s.Path)
+		if hash, err := sha256File(file); err != nil ||
+			(s.SHA256 != "" && hash != s.SHA256) {
+			if _, _, err = downloadURL(cpanMirrorURL+s.Path, file, s.SHA256); err != nil {
+				return err
+			}
+		}
+		_ = cache.Insert(cpanModuleName+"save", s.Path, true)
+	}
+	return writeCpanIndex(mirror)
+}
+
+// Apply implements Module. The distribution is installed with cpanm into the
+// local::lib of the project, taking the distributions from the local mirror
+// only.
+func (*cpanModule) Apply(anySpell any) error {
+	spell, err := types.To[cpanSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	if cache.Retrieve(cpanModuleName+"apply", spell.Path) != nil {
+		return nil
+	}
+	err = DeepApply(&spell.ExternalDependencies)
+	if err != nil {
+		return err
+	}
+	mirror, err := cpanMirror()
+	if err != nil {
+		return err
+	}
+	file := path.Join(mirror, "authors", "id", spell.Path)
+	if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s has not been saved, run `credo save` first.",
+			spell.Module)
+	}
+	cpanm, err := exec.LookPath("cpanm")
+	if err != nil {
+		return fmt.Errorf("cpanm not found: %v", err)
+	}
+	library := path.Join(path.Dir(mirror), cpanLibraryDirectory)
+	cmd := exec.Command(cpanm, "--mirror", "file://"+mirror, "--mirror-only",
+		"--notest", "--local-lib", library, file)
+	out, err := cmd.CombinedOutput()
+	logger.Get().Print(string(out))
+	if err != nil {
+		return fmt.Errorf("installing %s: %v", spell.Module, err)
+	}
+	logger.Get().Printf("[cpan/apply]: %s installed, run `eval \"$(perl -I%s/lib/perl5 -Mlocal::lib=%s)\"` to use it.",
+		spell.Module, library, library)
+	_ = cache.Insert(cpanModuleName+"apply", spell.Path, true)
+	return nil
+}
+
+// BulkApply implements Module.
+func (m *cpanModule) BulkApply(config *Config) error {
+	for _, cs := range config.Cpan {
+		err := m.Apply(cs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package modules
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"credo/logger"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Base URL of the distribution files of CPAN.
+const cpanMirrorURL = "https://cpan.metacpan.org/authors/id/"
+
+// Path of the package index in a CPAN mirror.
+const cpanIndexFile = "modules/02packages.details.txt.gz"
+
+// Packages of the saved distribution files, indexed by path. The path of a
+// distribution file pins its version, so that a file is read once.
+var cpanMirrorPackages = map[string][]cpanPackage{}
+
+// Extensions of the distribution files which can be indexed.
+var cpanDistributionExtensions = []string{".tar.gz", ".tgz", ".tar.bz2", ".zip"}
+
+// cpanPackage is a line of the package index.
+type cpanPackage struct {
+	name    string
+	version string
+	path    string
+}
+
+// cpanDistributionExtension returns the extension of the distribution file,
+// or an empty string when it cannot be indexed.
+func cpanDistributionExtension(file string) string {
+	for _, extension := range cpanDistributionExtensions {
+		if strings.HasSuffix(file, extension) {
+			return extension
+		}
+	}
+	return ""
+}
+
+// cpanDistributionVersion returns the version of a distribution from the
+// name of its file, such as 1.7.8 for BioPerl-1.7.8.tar.gz.
+func cpanDistributionVersion(file string) string {
+	name := strings.TrimSuffix(path.Base(file), cpanDistributionExtension(file))
+	index := strings.LastIndex(name, "-")
+	if index < 0 {
+		return "undef"
+	}
+	return strings.TrimPrefix(name[index+1:], "v")
+}
+
+// cpanPackages returns the packages provided by the distribution file: the
+// ones of the provides of its META.json, or else one for each module of its
+// lib directory, with the version of the distribution.
+func cpanPackages(mirror string, authorPath string) ([]cpanPackage, error) {
+	if packages, ok := cpanMirrorPackages[authorPath]; ok {
+		return packages, nil
+	}
+	version := cpanDistributionVersion(authorPath)
+	modules := []string{}
+	var meta struct {
+		Provides map[string]struct {
+			Version json.RawMessage `json:"version"`
+		} `json:"provides"`
+	}
+	file := path.Join(mirror, "authors", "id", authorPath)
+	err := walkCpanDistribution(file, func(entry string, content io.Reader) error {
+		_, name, _ := strings.Cut(strings.TrimPrefix(entry, "./"), "/")
+		if name == "META.json" {
+			data, err := io.ReadAll(content)
+			if err != nil {
+				return err
+			}
+			if err = json.Unmarshal(data, &meta); err != nil {
+				logger.Get().Printf("[cpan/save]: the META.json of %s is invalid: %v",
+					authorPath, err)
+			}
+			return nil
+		}
+		module, found := strings.CutPrefix(name, "lib/")
+		if found && strings.HasSuffix(module, ".pm") {
+			modules = append(modules, strings.ReplaceAll(
+				strings.TrimSuffix(module, ".pm"), "/", "::"))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", authorPath, err)
+	}
+	packages := []cpanPackage{}
+	if len(meta.Provides) > 0 {
+		for name, provided := range meta.Provides {
+			packageVersion := "undef"
+			if v := strings.Trim(string(provided.Version), `"`); v != "" && v != "null" {
+				packageVersion = v
+			}
+			packages = append(packages, cpanPackage{name, packageVersion, authorPath})
+		}
+	} else {
+		for _, module := range modules {
+			packages = append(packages, cpanPackage{module, version, authorPath})
+		}
+	}
+	cpanMirrorPackages[authorPath] = packages
+	return packages, nil
+}
+
+// walkCpanDistribution calls visit with the name and the content of every
+// file of the distribution, a tar archive or a zip file.
+func walkCpanDistribution(file string, visit func(name string, content io.Reader) error) error {
+	extension := cpanDistributionExtension(file)
+	if extension == ".zip" {
+		archive, err := zip.OpenReader(file)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+		for _, entry := range archive.File {
+			if entry.FileInfo().IsDir() {
+				continue
+			}
+			content, err := entry.Open()
+			if err != nil {
+				return err
+			}
+			err = visit(entry.Name, content)
+			if closeErr := content.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if extension == "" {
+		return fmt.Errorf("Unknown CPAN distribution format: %s", path.Base(file))
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader, wait, err := decompressedReader(f, archiveCompression(extension))
+	if err != nil {
+		return err
+	}
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err = visit(header.Name, archive); err != nil {
+			return err
+		}
+	}
+	return wait()
+}
+
+// cpanIndex returns the package index of the distribution files of the
+// mirror, sorted by package name.
+func cpanIndex(mirror string, date time.Time) ([]byte, error) {
+	packages := []cpanPackage{}
+	authors := path.Join(mirror, "authors", "id")
+	err := filepath.WalkDir(authors, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if cpanDistributionExtension(file) == "" {
+			return nil
+		}
+		authorPath, err := filepath.Rel(authors, file)
+		if err != nil {
+			return err
+		}
+		provided, err := cpanPackages(mirror, filepath.ToSlash(authorPath))
+		if err != nil {
+			return err
+		}
+		packages = append(packages, provided...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(packages, func(a, b cpanPackage) int {
+		if c := strings.Compare(strings.ToLower(a.name), strings.ToLower(b.name)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.path, b.path)
+	})
+	var index bytes.Buffer
+	fmt.Fprintf(&index, "File:         02packages.details.txt\n")
+	fmt.Fprintf(&index, "Description:  Package names found in directory $CPAN/authors/id/\n")
+	fmt.Fprintf(&index, "Columns:      package name, version, path\n")
+	fmt.Fprintf(&index, "Written-By:   CREDO\n")
+	fmt.Fprintf(&index, "Line-Count:   %d\n", len(packages))
+	fmt.Fprintf(&index, "Last-Updated: %s\n\n", date.UTC().Format(time.RFC1123))
+	for _, p := range packages {
+		fmt.Fprintf(&index, "%-40s %8s  %s\n", p.name, p.version, p.path)
+	}
+	return index.Bytes(), nil
+}
+
+// writeCpanIndex writes the package index of the saved distribution files,
+// so that the directory can be used as a CPAN mirror.
+func writeCpanIndex(mirror string) error {
+	index, err := cpanIndex(mirror, time.Now())
+	if err != nil {
+		return err
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err = writer.Write(index); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	file := path.Join(mirror, cpanIndexFile)
+	if err = os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, compressed.Bytes(), 0644)
+}
@@ -0,0 +1,199 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Default MetaCPAN API, overridden by the CREDO_METACPAN environment
+// variable.
+const cpanDefaultAPI = "https://fastapi.metacpan.org/v1"
+
+// Phases of the dependencies needed to build and run a distribution. Tests
+// are not run when applied.
+var cpanPhases = []string{"configure", "build", "runtime"}
+
+// cpanDownload is a release selected by MetaCPAN for a module.
+type cpanDownload struct {
+	DownloadURL string `json:"download_url"`
+	Release     string `json:"release"`
+	SHA256      string `json:"checksum_sha256"`
+}
+
+// cpanDependency is a dependency of a release.
+type cpanDependency struct {
+	Module       string `json:"module"`
+	Phase        string `json:"phase"`
+	Relationship string `json:"relationship"`
+	Version      string `json:"version"`
+}
+
+// cpanRelease is a release of a distribution.
+type cpanRelease struct {
+	Distribution string `json:"distribution"`
+	// Version is kept as written, as a number such as 1.70 would lose its
+	// trailing zero.
+	Version    json.RawMessage  `json:"version"`
+	Dependency []cpanDependency `json:"dependency"`
+}
+
+// cpanResolver resolves the closure of the dependencies of a module with the
+// metadata of MetaCPAN.
+type cpanResolver struct {
+	api string
+	// core returns the modules, written as name=version, provided by the
+	// core of perl.
+	core func(modules []string) (map[string]bool, error)
+	// Modules and distributions resolved, by name.
+	modules  map[string]bool
+	resolved map[string]bool
+	// Spells in installation order, dependencies first.
+	order []cpanSpell
+}
+
+// newCpanResolver returns a resolver using MetaCPAN and the core modules of
+// the perl of the host.
+func newCpanResolver() *cpanResolver {
+	api := userSetting("METACPAN")
+	if api == "" {
+		api = cpanDefaultAPI
+	}
+	return &cpanResolver{api: strings.TrimSuffix(api, "/"), core: cpanCoreModules,
+		modules: map[string]bool{}, resolved: map[string]bool{}}
+}
+
+// cpanCoreModules returns the modules, written as name=version, which the
+// core of perl provides in the required version.
+func cpanCoreModules(modules []string) (map[string]bool, error) {
+	core := map[string]bool{}
+	if len(modules) == 0 {
+		return core, nil
+	}
+	script := `use Module::CoreList;
+for (@ARGV) {
+	my ($module, $version) = split /=/, $_, 2;
+	print "$_\n" if Module::CoreList::is_core($module, $version ? $version : undef);
+}`
+	output, err := exec.Command("perl", append([]string{"-e", script}, modules...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing the core perl modules: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line != "" {
+			core[line] = true
+		}
+	}
+	return core, nil
+}
+
+// get decodes the document of the API at the path.
+func (r *cpanResolver) get(path string, document any) error {
+	response, err := httpClient.Get(r.api + path)
+	if err != nil {
+		return fmt.Errorf("querying MetaCPAN: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("querying MetaCPAN %s: %s", path, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(document)
+}
+
+// cpanModuleRequirement splits a module written as Module@version, the
+// syntax of cpanm, into the module and the version range of MetaCPAN.
+func cpanModuleRequirement(module string) (string, string) {
+	if name, version, found := strings.Cut(module, "@"); found {
+		return name, "== " + version
+	}
+	return module, ""
+}
+
+// cpanAuthorPath returns the path of the distribution file under
+// authors/id, such as C/CJ/CJFIELDS/BioPerl-1.7.8.tar.gz. Distribution files
+// which cannot be indexed in the mirror are refused.
+func cpanAuthorPath(downloadURL string) (string, error) {
+	_, path, found := strings.Cut(downloadURL, "/authors/id/")
+	if !found || strings.Count(path, "/") < 3 {
+		return "", fmt.Errorf("Unknown CPAN download url: %s", downloadURL)
+	}
+	if cpanDistributionExtension(path) == "" {
+		return "", fmt.Errorf("The CPAN distribution %s is not one of %s.",
+			path, strings.Join(cpanDistributionExtensions, ", "))
+	}
+	return path, nil
+}
+
+// resolve adds the distribution providing the module, in a version within
+// the range, after the closure of its dependencies. It returns the spell of
+// the distribution, or nil when it is perl itself or already resolved.
+func (r *cpanResolver) resolve(module string, versionRange string) (*cpanSpell, error) {
+	if r.modules[module] {
+		return nil, nil
+	}
+	r.modules[module] = true
+	query := "/download_url/" + url.PathEscape(module)
+	if versionRange != "" {
+		query += "?version=" + url.QueryEscape(versionRange)
+	}
+	var download cpanDownload
+	if err := r.get(query, &download); err != nil {
+		return nil, fmt.Errorf("resolving %s: %v", module, err)
+	}
+	path, err := cpanAuthorPath(download.DownloadURL)
+	if err != nil {
+		return nil, err
+	}
+	author := strings.Split(path, "/")[2]
+	var release struct {
+		Release cpanRelease `json:"release"`
+	}
+	err = r.get("/release/"+url.PathEscape(author)+"/"+url.PathEscape(download.Release),
+		&release)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %v", module, err)
+	}
+	distribution := release.Release.Distribution
+	if distribution == "perl" || r.resolved[distribution] {
+		return nil, nil
+	}
+	r.resolved[distribution] = true
+	required := []string{}
+	for _, dependency := range release.Release.Dependency {
+		if dependency.Relationship != "requires" || dependency.Module == "perl" ||
+			!slices.Contains(cpanPhases, dependency.Phase) {
+			continue
+		}
+		required = append(required, dependency.Module+"="+dependency.Version)
+	}
+	core, err := r.core(required)
+	if err != nil {
+		return nil, err
+	}
+	for _, requirement := range required {
+		if core[requirement] {
+			continue
+		}
+		name, version, _ := strings.Cut(requirement, "=")
+		versionRange := ""
+		if version != "" && version != "0" {
+			versionRange = ">= " + version
+		}
+		if _, err := r.resolve(name, versionRange); err != nil {
+			return nil, err
+		}
+	}
+	spell := cpanSpell{
+		Module:       module,
+		Distribution: distribution,
+		Version:      strings.Trim(string(release.Release.Version), `"`),
+		Path:         path,
+		SHA256:       download.SHA256,
+	}
+	r.order = append(r.order, spell)
+	return &spell, nil
+}
@@ -0,0 +1,191 @@
+package modules
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_cpanResolverResolve(t *testing.T) {
+	documents := map[string]string{
+		"/download_url/Bio::Perl": `{"download_url": "https://cpan.metacpan.org/authors/id/C/CJ/CJFIELDS/BioPerl-1.7.8.tar.gz",
+			"release": "BioPerl-1.7.8", "checksum_sha256": "b10"}`,
+		"/release/CJFIELDS/BioPerl-1.7.8": `{"release": {"distribution": "BioPerl", "version": "1.7.8", "dependency": [
+			{"module": "perl", "phase": "runtime", "relationship": "requires", "version": "5.006"},
+			{"module": "File::Spec", "phase": "runtime", "relationship": "requires", "version": "0"},
+			{"module": "XML::LibXML", "phase": "runtime", "relationship": "requires", "version": "1.70"},
+			{"module": "Test::Most", "phase": "test", "relationship": "requires", "version": "0"},
+			{"module": "Graph", "phase": "runtime", "relationship": "recommends", "version": "0"},
+			{"module": "XML::LibXML::Reader", "phase": "build", "relationship": "requires", "version": "0"}]}}`,
+		"/download_url/XML::LibXML?version=%3E%3D+1.70": `{"download_url": "https://cpan.metacpan.org/authors/id/S/SH/SHLOMIF/XML-LibXML-2.0210.tar.gz",
+			"release": "XML-LibXML-2.0210", "checksum_sha256": "x10"}`,
+		"/release/SHLOMIF/XML-LibXML-2.0210": `{"release": {"distribution": "XML-LibXML", "version": 2.0210, "dependency": [
+			{"module": "BioPerl::Cycle", "phase": "runtime", "relationship": "requires", "version": "0"}]}}`,
+		"/download_url/XML::LibXML::Reader": `{"download_url": "https://cpan.metacpan.org/authors/id/S/SH/SHLOMIF/XML-LibXML-2.0210.tar.gz",
+			"release": "XML-LibXML-2.0210", "checksum_sha256": "x10"}`,
+		"/download_url/BioPerl::Cycle": `{"download_url": "https://cpan.metacpan.org/authors/id/C/CJ/CJFIELDS/BioPerl-1.7.8.tar.gz",
+			"release": "BioPerl-1.7.8", "checksum_sha256": "b10"}`,
+	}
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := r.URL.EscapedPath()
+		if r.URL.RawQuery != "" {
+			request += "?" + r.URL.RawQuery
+		}
+		requests = append(requests, request)
+		document, ok := documents[strings.ReplaceAll(request, "%3A", ":")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(document))
+	}))
+	defer server.Close()
+	resolver := &cpanResolver{
+		api: server.URL,
+		core: func(modules []string) (map[string]bool, error) {
+			return map[string]bool{"File::Spec=0": true}, nil
+		},
+		modules:  map[string]bool{},
+		resolved: map[string]bool{},
+	}
+	root, err := resolver.resolve(cpanModuleRequirement("Bio::Perl"))
+	if err != nil {
+		t.Fatal(err, requests)
+	}
+	want := []cpanSpell{
+		{Module: "XML::LibXML", Distribution: "XML-LibXML", Version: "2.0210",
+			Path: "S/SH/SHLOMIF/XML-LibXML-2.0210.tar.gz", SHA256: "x10"},
+		{Module: "Bio::Perl", Distribution: "BioPerl", Version: "1.7.8",
+			Path: "C/CJ/CJFIELDS/BioPerl-1.7.8.tar.gz", SHA256: "b10"},
+	}
+	if !reflect.DeepEqual(resolver.order, want) {
+		t.Errorf("resolve() order = %v, want %v", resolver.order, want)
+	}
+	if root == nil || !reflect.DeepEqual(*root, want[1]) {
+		t.Errorf("resolve() = %v, want %v", root, want[1])
+	}
+	if name, versionRange := cpanModuleRequirement("Bio::Perl@1.7.8"); name != "Bio::Perl" ||
+		versionRange != "== 1.7.8" {
+		t.Errorf("cpanModuleRequirement() = %v, %v", name, versionRange)
+	}
+}
+
+// cpanTestDistribution writes a distribution file with the files, by name.
+func cpanTestDistribution(t *testing.T, file string, files map[string]string) {
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	writer := tar.NewWriter(gz)
+	for name, content := range files {
+		err := writer.WriteHeader(&tar.Header{Name: name, Mode: 0644,
+			Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte(content))
+	}
+	writer.Close()
+	gz.Close()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// cpanTestZipDistribution writes a zip distribution file with the files, by
+// name.
+func cpanTestZipDistribution(t *testing.T, file string, files map[string]string) {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for name, content := range files {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte(content))
+	}
+	writer.Close()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_cpanIndex(t *testing.T) {
+	mirror := t.TempDir()
+	cpanTestDistribution(t, filepath.Join(mirror, "authors/id/A/AU/AUTHOR/Foo-Bar-1.02.tar.gz"),
+		map[string]string{
+			"Foo-Bar-1.02/lib/Foo/Bar.pm":       "package Foo::Bar;",
+			"Foo-Bar-1.02/lib/Foo/Bar/Baz.pm":   "package Foo::Bar::Baz;",
+			"Foo-Bar-1.02/t/basic.t":            "",
+			"Foo-Bar-1.02/lib/Foo/Bar/data.txt": "",
+		})
+	cpanTestDistribution(t, filepath.Join(mirror, "authors/id/O/OT/OTHER/Alpha-v2.0.tar.gz"),
+		map[string]string{
+			"Alpha-v2.0/META.json":     `{"provides": {"Alpha": {"file": "lib/Alpha.pm", "version": "2.000"}, "Alpha::Util": {"file": "lib/Alpha/Util.pm"}}}`,
+			"Alpha-v2.0/lib/Alpha.pm":  "package Alpha;",
+			"Alpha-v2.0/lib/Hidden.pm": "package Hidden;",
+		})
+	cpanTestZipDistribution(t, filepath.Join(mirror, "authors/id/Z/ZI/ZIPPER/Zed-0.5.zip"),
+		map[string]string{
+			"Zed-0.5/lib/Zed.pm": "package Zed;",
+		})
+	index, err := cpanIndex(mirror, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, lines, _ := strings.Cut(string(index), "\n\n")
+	got := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(lines), "\n") {
+		got = append(got, strings.Join(strings.Fields(line), " "))
+	}
+	want := []string{
+		"Alpha 2.000 O/OT/OTHER/Alpha-v2.0.tar.gz",
+		"Alpha::Util undef O/OT/OTHER/Alpha-v2.0.tar.gz",
+		"Foo::Bar 1.02 A/AU/AUTHOR/Foo-Bar-1.02.tar.gz",
+		"Foo::Bar::Baz 1.02 A/AU/AUTHOR/Foo-Bar-1.02.tar.gz",
+		"Zed 0.5 Z/ZI/ZIPPER/Zed-0.5.zip",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cpanIndex() = %v, want %v", got, want)
+	}
+	if !strings.Contains(string(index), "Line-Count:   5\n") {
+		t.Errorf("cpanIndex() has a wrong line count:\n%s", index)
+	}
+}
+
+func Test_cpanAuthorPath(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://cpan.metacpan.org/authors/id/C/CJ/CJFIELDS/BioPerl-1.7.8.tar.gz",
+			"C/CJ/CJFIELDS/BioPerl-1.7.8.tar.gz"},
+		{"https://cpan.metacpan.org/authors/id/A/AU/AUTHOR/Tool-1.0.tar.bz2",
+			"A/AU/AUTHOR/Tool-1.0.tar.bz2"},
+		{"https://cpan.metacpan.org/authors/id/A/AU/AUTHOR/Tool-1.0.zip",
+			"A/AU/AUTHOR/Tool-1.0.zip"},
+		{"https://cpan.metacpan.org/authors/id/A/AU/AUTHOR/Tool-1.0.tar.xz", ""},
+		{"https://cpan.metacpan.org/Tool-1.0.tar.gz", ""},
+	}
+	for _, test := range tests {
+		got, err := cpanAuthorPath(test.url)
+		if got != test.expected || (err != nil) != (test.expected == "") {
+			t.Errorf("cpanAuthorPath(%s) = %s, %v, want %s", test.url, got, err,
+				test.expected)
+		}
+	}
+}
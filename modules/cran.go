@@ -293,7 +293,7 @@ func (c *cranModule) cobraRun(cfg *Config) func(*cobra.Command, []string) {
 
 func (c *cranModule) installApt(config *Config) error {
 	if _, ok := Modules["apt"]; !ok {
-		return nil
+		return installDnf(config, []string{"R-core", "R-core-devel"})
 	}
 	apt := aptModule{}
 	packages := []string{"r-base", "r-base-dev"}
@@ -405,7 +405,7 @@ func (c *cranModule) bareRunSingle(s cranSpell) (*cranSpell, error) {
 		module, ok := Modules[d.PackageManager]
 		if ok {
 			args := []string{d.Name}
-			module().CliConfig(&finalSpell.ExternalDependencies).Run(nil, args)
+			runModuleCli(module, &finalSpell.ExternalDependencies, args)
 		}
 	}
 	_ = cache.Insert(cranModuleName+"bare", s.PackageName, finalSpell)
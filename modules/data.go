@@ -0,0 +1,448 @@
+package modules
+
+import (
+	"credo/cache"
+	"credo/logger"
+	"credo/project"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/CREDOProject/sharedutils/types"
+	"github.com/spf13/cobra"
+)
+
+const dataModuleName = "data"
+
+const dataModuleShort = "Retrieves a dataset, such as a reference genome, from an URL."
+
+const dataModuleExample = `
+Add a reference genome, decompressed when applied:
+	credo data GRCh38.primary_assembly https://example.org/GRCh38.primary_assembly.genome.fa.gz
+
+Add an annotation, checking its hash and keeping it compressed:
+	credo data GRCh38.annotation https://example.org/gencode.v44.gtf.gz --sha256 <hash> --decompress none
+`
+
+const (
+	// Directory of the downloaded files, named after their hash, in the
+	// directory of the module.
+	dataObjectsDirectory = "objects"
+	// Directory of the datasets, named after their logical name, in the
+	// directory of the module.
+	dataNamedDirectory = "named"
+)
+
+// Extensions of the compressed files, by compression.
+var dataCompressions = map[string]string{
+	"gzip":  ".gz",
+	"bzip2": ".bz2",
+	"xz":    ".xz",
+	"zstd":  ".zst",
+}
+
+// Registers the dataModule.
+func init() { Register(dataModuleName, func() Module { return &dataModule{} }) }
+
+// dataModule is used to manage the data scope in the credospell
+// configuration.
+type dataModule struct{}
+
+type dataSpell struct {
+	// Name is the logical name of the dataset, such as
+	// GRCh38.primary_assembly. Several files can share it.
+	Name   string `yaml:"name"`
+	Source string `yaml:"source"`
+	// SHA256 and Size are the ones of the downloaded file.
+	SHA256 string `yaml:"sha256,omitempty"`
+	Size   int64  `yaml:"size,omitempty"`
+	// Decompress is the compression, such as gzip, removed when applied.
+	Decompress string `yaml:"decompress,omitempty"`
+	// ExternalDependencies are the spells required by the file, the
+	// decompressor run when it is applied.
+	ExternalDependencies Config `yaml:"external_dependencies,omitempty"`
+}
+
+// Function used to check if two dataSpell objects are equal.
+// It takes in an equatable interface as a parameter and returns a boolean
+// value indicating whether the two objects are equal or not.
+// The function first checks if the input parameter t is of type dataSpell.
+//
+// If it is, it proceeds to compare the Name, Source and SHA256 of the two
+// objects.
+// The function returns true if the two objects are equal.
+// Otherwise, it returns false.
+func (d dataSpell) equals(t equatable) bool {
+	o, err := types.To[dataSpell](t)
+	if err != nil {
+		return false
+	}
+	return o.Name == d.Name && o.Source == d.Source &&
+		strings.EqualFold(o.SHA256, d.SHA256)
+}
+
+// fileName returns the name of the file the source points to.
+func (d dataSpell) fileName() string {
+	name := path.Base(d.Source)
+	if parsed, err := url.Parse(d.Source); err == nil {
+		name = path.Base(parsed.Path)
+	}
+	if name == "." || name == "/" {
+		return "data"
+	}
+	return name
+}
+
+// appliedName returns the name of the file once applied, without the
+// extension of the compression removed.
+func (d dataSpell) appliedName() string {
+	name := d.fileName()
+	if extension, ok := dataCompressions[d.Decompress]; ok {
+		if trimmed := strings.TrimSuffix(name, extension); trimmed != "" {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// object returns the path of the downloaded file in the store.
+func (d dataSpell) object(dataPath string) string {
+	return path.Join(dataPath, dataObjectsDirectory, strings.ToLower(d.SHA256))
+}
+
+// link returns the predictable path of the applied file.
+func (d dataSpell) link(dataPath string) string {
+	return path.Join(dataPath, dataNamedDirectory, d.Name, d.appliedName())
+}
+
+// validate checks the logical name and the compression of the spell.
+func (d dataSpell) validate() error {
+	if d.Name == "" || !filepath.IsLocal(d.Name) || strings.Contains(d.Name, "/") {
+		return fmt.Errorf("%w data name %s is not a plain name.",
+			ErrInvalidSpell, d.Name)
+	}
+	if _, ok := dataCompressions[d.Decompress]; d.Decompress != "" && !ok {
+		return fmt.Errorf("%w data decompression %s is not one of gzip, bzip2, xz and zstd.",
+			ErrInvalidSpell, d.Decompress)
+	}
+	return nil
+}
+
+// dataCompression returns the compression of the file from its extension,
+// or an empty string when it is not compressed.
+func dataCompression(name string) string {
+	for compression, extension := range dataCompressions {
+		if strings.HasSuffix(strings.ToLower(name), extension) {
+			return compression
+		}
+	}
+	return ""
+}
+
+// dataPath returns the directory of the module in the project.
+func dataPath() (string, error) {
+	project, err := project.ProjectPath()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(*project, dataModuleName), nil
+}
+
+// BulkSave implements Module.
+func (m *dataModule) BulkSave(config *Config) error {
+	for _, ds := range config.Data {
+		err := m.Save(ds)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CliConfig implements Module.
+func (m *dataModule) CliConfig(config *Config) *cobra.Command {
+	command := &cobra.Command{
+		Args:    m.cobraArgs(),
+		Example: dataModuleExample,
+		Run:     m.cobraRun(config),
+		Short:   dataModuleShort,
+		Use:     dataModuleName + " <name> <url>",
+	}
+	command.Flags().String("sha256", "", "Expected SHA-256 hash of the file.")
+	command.Flags().String("decompress", "",
+		"Compression removed when applied: gzip, bzip2, xz, zstd or none. Guessed from the extension when empty.")
+	return command
+}
+
+// Function used to validate the arguments passed to the data command.
+// If the name and the url are not passed, it returns an error.
+// Otherwise it returns nil.
+//
+// Intended to be used by cobra.
+func (m *dataModule) cobraArgs() func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("%s module requires a name and an url.",
+				dataModuleName)
+		}
+		parsed, err := url.Parse(args[1])
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("\"%s\" doesn't look like an http url.", args[1])
+		}
+		return nil
+	}
+}
+
+// Function used to run the module from the command line.
+// It serves as an entry point to the bare run of the dataModule.
+//
+// Intended to be used by cobra.
+func (m *dataModule) cobraRun(config *Config) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, args []string) {
+		sha256, _ := cmd.Flags().GetString("sha256")
+		decompress, _ := cmd.Flags().GetString("decompress")
+		switch decompress {
+		case "":
+			decompress = dataCompression(args[1])
+		case "none":
+			decompress = ""
+		}
+		spell, err := m.bareRun(dataSpell{
+			Name:       args[0],
+			Source:     args[1],
+			SHA256:     sha256,
+			Decompress: decompress,
+		})
+		if err != nil {
+			logger.Get().Fatal(err)
+		}
+		err = m.Commit(config, spell)
+		if err != nil && err != ErrAlreadyPresent {
+			logger.Get().Fatal(err)
+		}
+	}
+}
+
+// bareRun records the hash and the size of the file. When the hash is given,
+// the size is asked to the server and the file is downloaded when saved;
+// otherwise the file is downloaded into the store to hash it.
+// The system packages of the decompressor are recorded as well.
+func (*dataModule) bareRun(s dataSpell) (dataSpell, error) {
+	if spell := cache.Retrieve(dataModuleName+"bare", s.Name+" "+s.Source); spell != nil {
+		newSpell, err := types.To[dataSpell](spell)
+		if err == nil {
+			return *newSpell, nil
+		}
+	}
+	if err := s.validate(); err != nil {
+		return dataSpell{}, err
+	}
+	if s.SHA256 != "" {
+		s.Size = dataRemoteSize(s.Source)
+	} else {
+		dataPath, err := dataPath()
+		if err != nil {
+			return dataSpell{}, err
+		}
+		// The download is named after the source until its hash is known,
+		// so that it resumes when interrupted.
+		downloaded := path.Join(dataPath, dataObjectsDirectory,
+			fmt.Sprintf("download-%x", sha256.Sum256([]byte(s.Source))))
+		s.SHA256, s.Size, err = downloadURL(s.Source, downloaded, "")
+		if err != nil {
+			return dataSpell{}, err
+		}
+		if err = os.Rename(downloaded, s.object(dataPath)); err != nil {
+			return dataSpell{}, err
+		}
+		// The object has just been hashed, Save does not hash it again.
+		_ = cache.Insert(dataModuleName+"downloaded", s.SHA256, true)
+	}
+	recordSystemPackages(&s.ExternalDependencies, decompressorPackages[s.Decompress])
+	_ = cache.Insert(dataModuleName+"bare", s.Name+" "+s.Source, s)
+	return s, nil
+}
+
+// dataRemoteSize returns the size of the file announced by the server, or
+// zero when it is unknown.
+func dataRemoteSize(source string) int64 {
+	response, err := httpClient.Head(source)
+	if err != nil {
+		return 0
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.ContentLength < 0 {
+		return 0
+	}
+	return response.ContentLength
+}
+
+// Commit implements Module.
+func (*dataModule) Commit(config *Config, result any) error {
+	newEntry, err := types.To[dataSpell](result)
+	if err != nil {
+		return ErrConverting
+	}
+	if Contains(config.Data, *newEntry) {
+		return ErrAlreadyPresent
+	}
+	config.Data = append(config.Data, *newEntry)
+	return nil
+}
+
+// Save implements Module. The file is downloaded into the store unless it is
+// already there, resuming an interrupted download, and its size and hash are
+// checked.
+func (*dataModule) Save(anySpell any) error {
+	spell, err := types.To[dataSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	if spell.SHA256 == "" {
+		return fmt.Errorf("%w data %s has no sha256.", ErrInvalidSpell, spell.Name)
+	}
+	if cache.Retrieve(dataModuleName+"save", spell.SHA256) != nil {
+		return nil
+	}
+	err = DeepSave(&spell.ExternalDependencies)
+	if err != nil {
+		return err
+	}
+	dataPath, err := dataPath()
+	if err != nil {
+		return err
+	}
+	object := spell.object(dataPath)
+	// The store is content addressed, but an object may have been truncated
+	// or altered since it was downloaded: its hash is checked, unless this
+	// process has just downloaded it.
+	if !dataObjectValid(object, spell) {
+		_, size, err := downloadURL(spell.Source, object, spell.SHA256)
+		if err != nil {
+			return err
+		}
+		if spell.Size != 0 && size != spell.Size {
+			return fmt.Errorf("%s has size %d, expected %d.", spell.Source,
+				size, spell.Size)
+		}
+	}
+	_ = cache.Insert(dataModuleName+"save", spell.SHA256, true)
+	return nil
+}
+
+// dataObjectValid reports whether the object in the store has the size and
+// the hash of the spell.
+func dataObjectValid(object string, spell *dataSpell) bool {
+	info, err := os.Stat(object)
+	if err != nil || (spell.Size != 0 && info.Size() != spell.Size) {
+		return false
+	}
+	if cache.Retrieve(dataModuleName+"downloaded", spell.SHA256) != nil {
+		return true
+	}
+	hash, err := sha256File(object)
+	if err != nil || !strings.EqualFold(hash, spell.SHA256) {
+		logger.Get().Printf("[data/save]: %s does not match its hash, downloading it again.",
+			spell.Name)
+		return false
+	}
+	return true
+}
+
+// Apply implements Module. The file is linked to its predictable path,
+// named/<name>/<file>, after being decompressed into the store.
+func (*dataModule) Apply(anySpell any) error {
+	spell, err := types.To[dataSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	if err = spell.validate(); err != nil {
+		return err
+	}
+	err = DeepApply(&spell.ExternalDependencies)
+	if err != nil {
+		return err
+	}
+	dataPath, err := dataPath()
+	if err != nil {
+		return err
+	}
+	target := spell.object(dataPath)
+	if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s has not been saved, run `credo save` first.",
+			spell.Name)
+	}
+	if spell.Decompress != "" {
+		decompressed := target + "." + spell.Decompress + ".out"
+		if err = decompressFile(target, decompressed, spell.Decompress); err != nil {
+			return err
+		}
+		target = decompressed
+	}
+	link := spell.link(dataPath)
+	if err = os.MkdirAll(path.Dir(link), 0755); err != nil {
+		return err
+	}
+	relative, err := filepath.Rel(path.Dir(link), target)
+	if err != nil {
+		return err
+	}
+	if err = replaceWithSymlink(relative, link); err != nil {
+		return err
+	}
+	logger.Get().Printf("[data/apply]: %s available at %s.", spell.Name, link)
+	return nil
+}
+
+// decompressFile decompresses the file into the target, unless it has
+// already been, through a partial file renamed once complete.
+func decompressFile(file string, target string, compression string) error {
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader, wait, err := decompressedReader(f, compression)
+	if err != nil {
+		return fmt.Errorf("decompressing %s: %v", path.Base(file), err)
+	}
+	partial := target + ".part"
+	out, err := os.Create(partial)
+	if err != nil {
+		wait()
+		return err
+	}
+	_, err = io.Copy(out, reader)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if waitErr := wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("decompressing %s: %v", path.Base(file), err)
+	}
+	return os.Rename(partial, target)
+}
+
+// BulkApply implements Module.
+func (m *dataModule) BulkApply(config *Config) error {
+	for _, ds := range config.Data {
+		err := m.Apply(ds)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
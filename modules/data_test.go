@@ -0,0 +1,156 @@
+package modules
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_downloadURLResume(t *testing.T) {
+	content := bytes.Repeat([]byte(">chr1\nACGT\n"), 1000)
+	ranges := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "genome.fa", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	sum := sha256.Sum256(content)
+	expected := hex.EncodeToString(sum[:])
+
+	file := filepath.Join(t.TempDir(), "genome.fa")
+	if err := os.WriteFile(file+".part", content[:5000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, size, err := downloadURL(server.URL+"/genome.fa", file, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != expected || size != int64(len(content)) {
+		t.Errorf("downloadURL() = %v, %v, want %v, %v", hash, size, expected, len(content))
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=5000-" {
+		t.Errorf("downloadURL() requested the ranges %v, want [bytes=5000-]", ranges)
+	}
+	downloaded, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("downloadURL() resumed into a different content")
+	}
+	if _, err := os.Stat(file + ".part"); err == nil {
+		t.Errorf("downloadURL() kept the partial file")
+	}
+	// The server answers an unsatisfiable range to a partial file which is
+	// complete, or longer than the content.
+	for _, partial := range [][]byte{content, append(content, "extra"...)} {
+		file := filepath.Join(t.TempDir(), "genome.fa")
+		if err := os.WriteFile(file+".part", partial, 0644); err != nil {
+			t.Fatal(err)
+		}
+		hash, size, err := downloadURL(server.URL+"/genome.fa", file, expected)
+		if err != nil || hash != expected || size != int64(len(content)) {
+			t.Errorf("downloadURL() = %v, %v, %v, want %v, %v", hash, size, err,
+				expected, len(content))
+		}
+	}
+}
+
+func Test_contentRangeSize(t *testing.T) {
+	for header, expected := range map[string]int64{
+		"bytes */11000": 11000, "bytes 0-9/11000": -1, "": -1, "bytes */*": -1,
+	} {
+		if size := contentRangeSize(header); size != expected {
+			t.Errorf("contentRangeSize(%q) = %d, want %d", header, size, expected)
+		}
+	}
+}
+
+func Test_dataSpellPaths(t *testing.T) {
+	spell := dataSpell{
+		Name:       "GRCh38.primary_assembly",
+		Source:     "https://example.org/GRCh38.primary_assembly.genome.fa.gz",
+		SHA256:     "ABCDEF",
+		Decompress: "gzip",
+	}
+	if err := spell.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := spell.object("/data"); got != "/data/objects/abcdef" {
+		t.Errorf("object() = %v", got)
+	}
+	want := "/data/named/GRCh38.primary_assembly/GRCh38.primary_assembly.genome.fa"
+	if got := spell.link("/data"); got != want {
+		t.Errorf("link() = %v, want %v", got, want)
+	}
+	spell.Decompress = ""
+	if got := spell.appliedName(); got != "GRCh38.primary_assembly.genome.fa.gz" {
+		t.Errorf("appliedName() = %v", got)
+	}
+	if got := dataCompression(spell.Source); got != "gzip" {
+		t.Errorf("dataCompression() = %v, want gzip", got)
+	}
+	for _, invalid := range []dataSpell{
+		{Name: "../outside"},
+		{Name: "a/b"},
+		{Name: "genome", Decompress: "rar"},
+	} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("validate() accepted %v", invalid)
+		}
+	}
+}
+
+func Test_dataObjectValid(t *testing.T) {
+	content := []byte(">chr1\nACGT\n")
+	sum := sha256.Sum256(content)
+	spell := &dataSpell{Name: "genome", SHA256: hex.EncodeToString(sum[:]),
+		Size: int64(len(content))}
+	object := filepath.Join(t.TempDir(), "object")
+	if dataObjectValid(object, spell) {
+		t.Error("dataObjectValid() accepted a missing object")
+	}
+	if err := os.WriteFile(object, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !dataObjectValid(object, spell) {
+		t.Error("dataObjectValid() rejected a valid object")
+	}
+	// An object of the same size with another content.
+	if err := os.WriteFile(object, []byte(">chr1\nNNNN\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if dataObjectValid(object, spell) {
+		t.Error("dataObjectValid() accepted an altered object")
+	}
+}
+
+func Test_decompressFile(t *testing.T) {
+	directory := t.TempDir()
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte("gene\tchr1\n"))
+	writer.Close()
+	file := filepath.Join(directory, "object")
+	if err := os.WriteFile(file, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := file + ".gzip.out"
+	if err := decompressFile(file, target, "gzip"); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "gene\tchr1\n" {
+		t.Errorf("decompressFile() = %q", content)
+	}
+}
@@ -0,0 +1,525 @@
+package modules
+
+import (
+	"bufio"
+	"cmp"
+	"credo/cache"
+	"credo/logger"
+	"credo/project"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	goosinfo "github.com/CREDOProject/go-osinfo"
+	"github.com/CREDOProject/sharedutils/types"
+	"github.com/spf13/cobra"
+)
+
+const dnfModuleName = "dnf"
+
+const dnfModuleShort = "Retrieves an rpm package and its dependencies with dnf."
+
+const dnfModuleExample = `
+Install an rpm package:
+	credo dnf python3
+
+Install a given version of an rpm package:
+	credo dnf samtools=1.19-1.el9
+`
+
+// Separator of the fields of the repoquery output.
+const dnfFieldSeparator = "|"
+
+// Registers the dnfModule.
+func init() {
+	osinfo, err := goosinfo.Retrieve()
+	if err != nil {
+		logger.Get().Fatal(err)
+	}
+	supportedDistributions := map[string]struct{}{
+		"fedora": {},
+		"rhel":   {},
+		"centos": {},
+	}
+	for _, distribution := range append(osinfo.Like, osinfo.Distribution) {
+		if _, ok := supportedDistributions[distribution]; ok {
+			Register(dnfModuleName, func() Module { return &dnfModule{} })
+			return
+		}
+	}
+}
+
+// dnfModule is used to manage the dnf scope in the credospell configuration.
+type dnfModule struct{}
+
+type dnfSpell struct {
+	Name string `yaml:"name"`
+	// Version is the epoch, when not zero, the version and the release of
+	// the package, such as 1:1.19-1.el9.
+	Version              string     `yaml:"version,omitempty"`
+	Architecture         string     `yaml:"architecture,omitempty"`
+	Dependencies         []dnfSpell `yaml:"dependencies,omitempty"`
+	ExternalDependencies Config     `yaml:"external_dependencies,omitempty"`
+}
+
+// Function used to check if two dnfSpell objects are equal.
+// It takes in an equatable interface as a parameter and returns a boolean
+// value indicating whether the two objects are equal or not.
+// The function first checks if the input parameter t is of type dnfSpell.
+//
+// If it is, it proceeds to compare the Name, Version and Architecture of the
+// two objects and all its other Dependencies.
+// The function returns true if the two objects are equal.
+// Otherwise, it returns false.
+func (d dnfSpell) equals(t equatable) bool {
+	o, err := types.To[dnfSpell](t)
+	if err != nil {
+		return false
+	}
+	equality := o.Name == d.Name && o.Version == d.Version &&
+		o.Architecture == d.Architecture &&
+		len(o.Dependencies) == len(d.Dependencies)
+	if !equality {
+		return false
+	}
+	for i := range o.Dependencies {
+		equality = equality &&
+			o.Dependencies[i].equals(d.Dependencies[i])
+	}
+	return equality
+}
+
+// nevra returns the name, epoch, version, release and architecture of the
+// package, selecting exactly it.
+func (d dnfSpell) nevra() string {
+	if d.Version == "" {
+		return d.Name
+	}
+	nevra := d.Name + "-" + d.Version
+	if d.Architecture != "" {
+		nevra += "." + d.Architecture
+	}
+	return nevra
+}
+
+// rpmFile returns the name of the package file of the spell. The epoch is not
+// part of it.
+func (d dnfSpell) rpmFile() string {
+	version := d.Version
+	if _, withoutEpoch, found := strings.Cut(version, ":"); found {
+		version = withoutEpoch
+	}
+	return fmt.Sprintf("%s-%s.%s.rpm", d.Name, version, d.Architecture)
+}
+
+// closure returns the dependencies of the spell followed by the spell itself.
+func (d dnfSpell) closure() []dnfSpell {
+	return append(slices.Clone(d.Dependencies), d)
+}
+
+// dnfBinary returns the dnf binary, or yum on the older distributions.
+func dnfBinary() (string, error) {
+	for _, name := range []string{"dnf", "yum"} {
+		if binary, err := exec.LookPath(name); err == nil {
+			return binary, nil
+		}
+	}
+	return "", errors.New("Neither dnf nor yum found.")
+}
+
+// dnfPackageName returns the package selection understood by dnf, written
+// either as a name or as name=version.
+func dnfPackageName(name string) string {
+	if pkg, version, found := strings.Cut(name, "="); found {
+		return pkg + "-" + version
+	}
+	return name
+}
+
+// dnfQueryFormat is the format of the packages listed by repoquery.
+var dnfQueryFormat = strings.Join([]string{"%{name}", "%{epoch}", "%{version}",
+	"%{release}", "%{arch}"}, dnfFieldSeparator) + `\n`
+
+// rpmVersionCompare compares two versions, or two releases, as rpmvercmp
+// does: alphabetic and numeric segments are compared in turn, a tilde sorts
+// before anything and a caret after the end of the version.
+func rpmVersionCompare(a string, b string) int {
+	isAlnum := func(c byte) bool {
+		return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+	}
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	for a != "" || b != "" {
+		for a != "" && !isAlnum(a[0]) && a[0] != '~' && a[0] != '^' {
+			a = a[1:]
+		}
+		for b != "" && !isAlnum(b[0]) && b[0] != '~' && b[0] != '^' {
+			b = b[1:]
+		}
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if a == "" {
+				return -1
+			}
+			if b == "" {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+		numeric := isDigit(a[0])
+		segment := func(s string) (string, string) {
+			i := 0
+			for i < len(s) && isAlnum(s[i]) && isDigit(s[i]) == numeric {
+				i++
+			}
+			return s[:i], s[i:]
+		}
+		var sa, sb string
+		sa, a = segment(a)
+		sb, b = segment(b)
+		if sb == "" {
+			// Numeric segments are newer than alphabetic ones.
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			sa, sb = strings.TrimLeft(sa, "0"), strings.TrimLeft(sb, "0")
+			if c := cmp.Compare(len(sa), len(sb)); c != 0 {
+				return c
+			}
+		}
+		if c := strings.Compare(sa, sb); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	}
+	return 1
+}
+
+// dnfVersionCompare compares two versions written as epoch:version-release,
+// the epoch being optional.
+func dnfVersionCompare(a string, b string) int {
+	split := func(evr string) (int, string, string) {
+		epoch := 0
+		if e, rest, found := strings.Cut(evr, ":"); found {
+			epoch, _ = strconv.Atoi(e)
+			evr = rest
+		}
+		index := strings.LastIndex(evr, "-")
+		if index < 0 {
+			return epoch, evr, ""
+		}
+		return epoch, evr[:index], evr[index+1:]
+	}
+	ea, va, ra := split(a)
+	eb, vb, rb := split(b)
+	if ea != eb {
+		return cmp.Compare(ea, eb)
+	}
+	if c := rpmVersionCompare(va, vb); c != 0 {
+		return c
+	}
+	return rpmVersionCompare(ra, rb)
+}
+
+// parseDnfPackages parses the packages listed by repoquery with
+// dnfQueryFormat, sorted by name. The newest version of a name and
+// architecture is kept.
+func parseDnfPackages(output []byte) []dnfSpell {
+	packages := []dnfSpell{}
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), dnfFieldSeparator)
+		if len(fields) != 5 {
+			continue
+		}
+		version := fields[2] + "-" + fields[3]
+		if fields[1] != "" && fields[1] != "0" && fields[1] != "(none)" {
+			version = fields[1] + ":" + version
+		}
+		spell := dnfSpell{Name: fields[0], Version: version, Architecture: fields[4]}
+		index := slices.IndexFunc(packages, func(p dnfSpell) bool {
+			return p.Name == spell.Name && p.Architecture == spell.Architecture
+		})
+		if index < 0 {
+			packages = append(packages, spell)
+		} else if dnfVersionCompare(spell.Version, packages[index].Version) > 0 {
+			packages[index] = spell
+		}
+	}
+	slices.SortStableFunc(packages, func(a, b dnfSpell) int {
+		return strings.Compare(a.Name+"."+a.Architecture, b.Name+"."+b.Architecture)
+	})
+	return packages
+}
+
+// dnfArchitectures returns the architectures installable on the host.
+func dnfArchitectures() (string, error) {
+	output, err := exec.Command("rpm", "--eval", "%{_arch}").Output()
+	if err != nil {
+		return "", fmt.Errorf("retrieving the rpm architecture: %v", err)
+	}
+	return strings.TrimSpace(string(output)) + ",noarch", nil
+}
+
+// dnfRepoquery lists the packages matching the query, in dnfQueryFormat.
+func dnfRepoquery(args ...string) ([]dnfSpell, error) {
+	binary, err := dnfBinary()
+	if err != nil {
+		return nil, err
+	}
+	architectures, err := dnfArchitectures()
+	if err != nil {
+		return nil, err
+	}
+	args = append([]string{"repoquery", "--quiet", "--latest-limit", "1",
+		"--arch", architectures, "--queryformat", dnfQueryFormat}, args...)
+	cmd := exec.Command(binary, args...)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running dnf repoquery: %v", err)
+	}
+	return parseDnfPackages(output), nil
+}
+
+// BulkSave implements Module.
+func (m *dnfModule) BulkSave(config *Config) error {
+	for _, ds := range config.Dnf {
+		err := m.Save(ds)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CliConfig implements Module.
+func (m *dnfModule) CliConfig(config *Config) *cobra.Command {
+	return &cobra.Command{
+		Args:    m.cobraArgs(),
+		Example: dnfModuleExample,
+		Run:     m.cobraRun(config),
+		Short:   dnfModuleShort,
+		Use:     dnfModuleName,
+	}
+}
+
+// Function used to validate the arguments passed to the dnf command.
+// If no arguments are passed, it returns an error.
+// Otherwise it returns nil.
+//
+// Intended to be used by cobra.
+func (m *dnfModule) cobraArgs() func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return fmt.Errorf("%s module requires at least one argument.",
+				dnfModuleName)
+		}
+		return nil
+	}
+}
+
+// Function used to run the module from the command line.
+// It serves as an entry point to the bare run of the dnfModule.
+//
+// Intended to be used by cobra.
+func (m *dnfModule) cobraRun(config *Config) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, args []string) {
+		spell, err := m.bareRun(dnfSpell{
+			Name: args[0],
+		})
+		if err != nil {
+			logger.Get().Fatal(err)
+		}
+		err = m.Commit(config, spell)
+		if err != nil && err != ErrAlreadyPresent {
+			logger.Get().Fatal(err)
+		}
+	}
+}
+
+// bareRun resolves the package and the closure of its requirements with
+// dnf, recording the version chosen for every package.
+func (*dnfModule) bareRun(s dnfSpell) (dnfSpell, error) {
+	if spell := cache.Retrieve(dnfModuleName+"bare", s.Name); spell != nil {
+		newSpell, err := types.To[dnfSpell](spell)
+		if err == nil {
+			return *newSpell, nil
+		}
+	}
+	name := dnfPackageName(s.Name)
+	found, err := dnfRepoquery(name)
+	if err != nil {
+		return dnfSpell{}, err
+	}
+	if len(found) == 0 {
+		return dnfSpell{}, fmt.Errorf("No rpm package matches %s.", s.Name)
+	}
+	spell := found[0]
+	spell.ExternalDependencies = s.ExternalDependencies
+	requirements, err := dnfRepoquery("--requires", "--resolve", "--recursive",
+		spell.nevra())
+	if err != nil {
+		return dnfSpell{}, err
+	}
+	for _, requirement := range requirements {
+		if requirement.Name != spell.Name {
+			spell.Dependencies = append(spell.Dependencies, requirement)
+		}
+	}
+	_ = cache.Insert(dnfModuleName+"bare", s.Name, spell)
+	return spell, nil
+}
+
+// Commit implements Module.
+func (*dnfModule) Commit(config *Config, result any) error {
+	newEntry, err := types.To[dnfSpell](result)
+	if err != nil {
+		return ErrConverting
+	}
+	if Contains(config.Dnf, *newEntry) {
+		return ErrAlreadyPresent
+	}
+	config.Dnf = append(config.Dnf, *newEntry)
+	return nil
+}
+
+// Save implements Module. The package files of the package and its
+// dependencies are downloaded in the recorded versions.
+func (*dnfModule) Save(anySpell any) error {
+	spell, err := types.To[dnfSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	project, err := project.ProjectPath()
+	if err != nil {
+		return err
+	}
+	downloadPath := path.Join(*project, dnfModuleName)
+	if err = os.MkdirAll(downloadPath, 0755); err != nil {
+		return err
+	}
+	missing := []string{}
+	for _, s := range spell.closure() {
+		if cache.Retrieve(dnfModuleName+"save", s.nevra()) != nil {
+			continue
+		}
+		if _, err := os.Stat(path.Join(downloadPath, s.rpmFile())); err == nil {
+			continue
+		}
+		missing = append(missing, s.nevra())
+	}
+	if len(missing) > 0 {
+		binary, err := dnfBinary()
+		if err != nil {
+			return err
+		}
+		args := append([]string{"download", "--destdir", downloadPath}, missing...)
+		out, err := exec.Command(binary, args...).CombinedOutput()
+		logger.Get().Print(string(out))
+		if err != nil {
+			return fmt.Errorf("downloading %s: %v", spell.nevra(), err)
+		}
+	}
+	for _, s := range spell.closure() {
+		_ = cache.Insert(dnfModuleName+"save", s.nevra(), true)
+	}
+	return nil
+}
+
+// Apply implements Module. The package and its dependencies are installed
+// from the saved package files, with every repository disabled.
+func (m *dnfModule) Apply(anySpell any) error {
+	spell, err := types.To[dnfSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	project, err := project.ProjectPath()
+	if err != nil {
+		return err
+	}
+	binary, err := dnfBinary()
+	if err != nil {
+		return err
+	}
+	downloadPath := path.Join(*project, dnfModuleName)
+	args := []string{"install", "-y", "--disablerepo=*"}
+	for _, s := range spell.closure() {
+		file := path.Join(downloadPath, s.rpmFile())
+		if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s has not been saved, run `credo save` first.",
+				s.nevra())
+		}
+		args = append(args, file)
+	}
+	out, err := exec.Command(binary, args...).CombinedOutput()
+	logger.Get().Print(string(out))
+	return err
+}
+
+// BulkApply implements Module.
+func (m *dnfModule) BulkApply(config *Config) error {
+	for _, ds := range config.Dnf {
+		err := m.Apply(ds)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installDnf resolves, saves and installs system packages with the
+// dnfModule, when it is available.
+func installDnf(config *Config, packages []string) error {
+	if _, ok := Modules[dnfModuleName]; !ok {
+		return nil
+	}
+	dnf := dnfModule{}
+	for _, v := range packages {
+		spell, err := dnf.bareRun(dnfSpell{Name: v})
+		if err != nil {
+			return fmt.Errorf("InstallDnf error barerun: %v", err)
+		}
+		if err = dnf.Commit(config, spell); err != nil && err != ErrAlreadyPresent {
+			return fmt.Errorf("InstallDnf error commiting: %v", err)
+		}
+		if err = dnf.Save(spell); err != nil {
+			return fmt.Errorf("InstallDnf error saving: %v", err)
+		}
+		if err = dnf.Apply(spell); err != nil {
+			return fmt.Errorf("InstallDnf error applying: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseDnfPackages(t *testing.T) {
+	output := []byte(`
+zlib|0|1.2.11|39.el9|x86_64
+samtools|0|1.19|1.el9|x86_64
+perl-libs|4|5.32.1|480.el9|x86_64
+zlib|0|1.2.11|40.el9|x86_64
+perl-libs|0|5.34.0|1.el9|x86_64
+tzdata|(none)|2024a|1.el9|noarch
+warning: truncated line
+`)
+	want := []dnfSpell{
+		{Name: "perl-libs", Version: "4:5.32.1-480.el9", Architecture: "x86_64"},
+		{Name: "samtools", Version: "1.19-1.el9", Architecture: "x86_64"},
+		{Name: "tzdata", Version: "2024a-1.el9", Architecture: "noarch"},
+		{Name: "zlib", Version: "1.2.11-40.el9", Architecture: "x86_64"},
+	}
+	if got := parseDnfPackages(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDnfPackages() = %v, want %v", got, want)
+	}
+}
+
+func Test_dnfVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.11-40.el9", "1.2.11-39.el9", 1},
+		{"1.10-1", "1.9-1", 1},
+		{"1:1.0-1", "2.0-1", 1},
+		{"1.0~rc1-1", "1.0-1", -1},
+		{"1.0^git1-1", "1.0-1", 1},
+		{"1.0a-1", "1.0.1-1", -1},
+		{"2024a-1.el9", "2024a-1.el9", 0},
+	}
+	for _, tt := range tests {
+		if got := dnfVersionCompare(tt.a, tt.b); got != tt.want {
+			t.Errorf("dnfVersionCompare(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func Test_dnfSpellFiles(t *testing.T) {
+	spell := dnfSpell{Name: "perl-libs", Version: "4:5.32.1-480.el9",
+		Architecture: "x86_64"}
+	if got := spell.nevra(); got != "perl-libs-4:5.32.1-480.el9.x86_64" {
+		t.Errorf("nevra() = %v", got)
+	}
+	if got := spell.rpmFile(); got != "perl-libs-5.32.1-480.el9.x86_64.rpm" {
+		t.Errorf("rpmFile() = %v", got)
+	}
+	if got := dnfPackageName("samtools=1.19-1.el9"); got != "samtools-1.19-1.el9" {
+		t.Errorf("dnfPackageName() = %v", got)
+	}
+}
@@ -0,0 +1,115 @@
+package modules
+
+import (
+	"credo/logger"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const exportModuleName = "export"
+
+const exportModuleShort = "Exports the credospell.yaml configuration to another format."
+
+const exportModuleExample = `
+Export the python dependencies saved for the host as a requirements file,
+the ones saved with --platform or --python-version being left out:
+	credo export requirements --output requirements.txt
+
+Export the conda and python dependencies as a conda environment file:
+	credo export conda --output environment.yml
+
+Export the saved conda packages as an explicit specification file:
+	credo export conda-lock --output conda-lock.txt
+`
+
+// Registers the exportModule.
+func init() { Register(exportModuleName, func() Module { return &exportModule{} }) }
+
+// exporter writes the configuration in a given format to output. An empty
+// output means the standard output.
+type exporter = func(config *Config, output string) error
+
+// Exporter registry.
+var exporters = map[string]exporter{}
+
+// registerExporter SHOULD BE called by the init() function of a provider.
+func registerExporter(format string, e exporter) {
+	if _, present := exporters[format]; present {
+		logger.Get().Fatalf("Exporter %s already defined.", format)
+	}
+	exporters[format] = e
+}
+
+// exportFormats returns the sorted list of the registered formats.
+func exportFormats() []string {
+	formats := []string{}
+	for format := range exporters {
+		formats = append(formats, format)
+	}
+	slices.Sort(formats)
+	return formats
+}
+
+// nopWriteCloser wraps the standard output, which must not be closed.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// exportWriter returns a writer to the output file, or to the standard output
+// if output is empty.
+func exportWriter(output string) (io.WriteCloser, error) {
+	if output == "" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.Create(output)
+}
+
+// exportModule is used to export the credospell configuration to the formats
+// understood by other tools.
+type exportModule struct{}
+
+// CliConfig implements Module.
+func (m *exportModule) CliConfig(config *Config) *cobra.Command {
+	command := &cobra.Command{
+		Use:       exportModuleName,
+		Short:     exportModuleShort,
+		Example:   exportModuleExample,
+		ValidArgs: exportFormats(),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("%s module requires one format among: %s.",
+					exportModuleName, strings.Join(exportFormats(), ", "))
+			}
+			return cobra.OnlyValidArgs(cmd, args)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			output, _ := cmd.Flags().GetString("output")
+			if err := exporters[args[0]](config, output); err != nil {
+				logger.Get().Fatal(err)
+			}
+		},
+	}
+	command.Flags().StringP("output", "o", "",
+		"File to write to. Defaults to the standard output.")
+	return command
+}
+
+// This is a stub method. It should always return nil.
+func (m *exportModule) Apply(any) error { return nil }
+
+// This is a stub method. It should always return nil.
+func (m *exportModule) BulkApply(config *Config) error { return nil }
+
+// This is a stub method. It should always return nil.
+func (m *exportModule) BulkSave(config *Config) error { return nil }
+
+// This is a stub method. It should always return nil.
+func (m *exportModule) Commit(config *Config, result any) error { return nil }
+
+// This is a stub method. It should always return nil.
+func (m *exportModule) Save(any) error { return nil }
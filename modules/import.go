@@ -0,0 +1,109 @@
+package modules
+
+import (
+	"credo/logger"
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+const importModuleName = "import"
+
+const importModuleShort = "Imports the dependencies declared in an existing file."
+
+const importModuleExample = `
+Import the dependencies of a requirements file:
+	credo import requirements.txt
+
+Import the dependencies of a python project, including an optional group:
+	credo import pyproject.toml --extra test
+
+Import the conda and python dependencies of a conda environment file:
+	credo import environment.yml
+`
+
+// Registers the importModule.
+func init() { Register(importModuleName, func() Module { return &importModule{} }) }
+
+// importOptions are the options given to an importer.
+type importOptions struct {
+	// Extras are the optional dependency groups to import, where supported.
+	Extras []string
+}
+
+// importer parses a dependency declaration file and commits its entries to
+// the configuration.
+type importer = func(config *Config, file string, options importOptions) error
+
+// Importer registry, in order of registration.
+var importers = []struct {
+	pattern  string
+	importer importer
+}{}
+
+// registerImporter SHOULD BE called by the init() function of a provider.
+// pattern is matched against the base name of the imported file, with the
+// syntax of path.Match.
+func registerImporter(pattern string, i importer) {
+	importers = append(importers, struct {
+		pattern  string
+		importer importer
+	}{pattern, i})
+}
+
+// importerFor returns the first importer whose pattern matches the file.
+func importerFor(file string) (importer, error) {
+	for _, entry := range importers {
+		if matched, _ := path.Match(entry.pattern, filepath.Base(file)); matched {
+			return entry.importer, nil
+		}
+	}
+	return nil, fmt.Errorf("No importer available for %s.", file)
+}
+
+// importModule is used to import existing dependency declarations into the
+// credospell configuration.
+type importModule struct{}
+
+// CliConfig implements Module.
+func (m *importModule) CliConfig(config *Config) *cobra.Command {
+	command := &cobra.Command{
+		Use:     importModuleName,
+		Short:   importModuleShort,
+		Example: importModuleExample,
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			extras, _ := cmd.Flags().GetStringSlice("extra")
+			options := importOptions{Extras: extras}
+			for _, file := range args {
+				importer, err := importerFor(file)
+				if err != nil {
+					logger.Get().Fatal(err)
+				}
+				if err = importer(config, file, options); err != nil {
+					logger.Get().Fatal(err)
+				}
+			}
+		},
+	}
+	command.Flags().StringSlice("extra", nil,
+		"Optional dependency group to import, where supported.")
+	return command
+}
+
+// This is a stub method. It should always return nil.
+func (m *importModule) Apply(any) error { return nil }
+
+// This is a stub method. It should always return nil.
+func (m *importModule) BulkApply(config *Config) error { return nil }
+
+// This is a stub method. It should always return nil.
+func (m *importModule) BulkSave(config *Config) error { return nil }
+
+// This is a stub method. It should always return nil.
+func (m *importModule) Commit(config *Config, result any) error { return nil }
+
+// This is a stub method. It should always return nil.
+func (m *importModule) Save(any) error { return nil }
@@ -0,0 +1,626 @@
+package modules
+
+import (
+	"archive/zip"
+	"bufio"
+	"credo/cache"
+	"credo/logger"
+	"credo/project"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/CREDOProject/sharedutils/types"
+	"github.com/spf13/cobra"
+)
+
+const mavenModuleName = "maven"
+
+const mavenModuleShort = "Retrieves a Maven artifact and its dependencies."
+
+const mavenModuleExample = `
+Install an artifact from Maven Central:
+	credo maven com.github.broadinstitute:picard:3.1.1
+
+Install an artifact from another repository:
+	credo maven org.example:tool:1.0 --repository https://maven.example.org/releases
+`
+
+// Versions of the JDK with long-term support, which are the ones packaged by
+// the distributions.
+var mavenJDKs = []int{8, 11, 17, 21, 25}
+
+// Packages of the Java runtime, by package manager, for a version of the JDK.
+var mavenJavaPackages = map[string]func(int) string{
+	"apt": func(jdk int) string { return fmt.Sprintf("openjdk-%d-jre-headless", jdk) },
+	"dnf": func(jdk int) string {
+		if jdk == 8 {
+			return "java-1.8.0-openjdk-headless"
+		}
+		return fmt.Sprintf("java-%d-openjdk-headless", jdk)
+	},
+	"apk": func(jdk int) string { return fmt.Sprintf("openjdk%d-jre-headless", jdk) },
+}
+
+// Packages of the default Java runtime of the distributions, by package
+// manager, used when none of the versions is packaged.
+var mavenDefaultJavaPackages = map[string]string{
+	"apt": "default-jre-headless",
+	"dnf": "java-latest-openjdk-headless",
+}
+
+// Registers the mavenModule.
+func init() { Register(mavenModuleName, func() Module { return &mavenModule{} }) }
+
+// mavenModule is used to manage the maven scope in the credospell
+// configuration.
+type mavenModule struct{}
+
+type mavenSpell struct {
+	GroupID    string `yaml:"group_id"`
+	ArtifactID string `yaml:"artifact_id"`
+	Version    string `yaml:"version"`
+	Classifier string `yaml:"classifier,omitempty"`
+	// Repository is the Maven repository the artifact comes from, Maven
+	// Central when empty.
+	Repository string `yaml:"repository,omitempty"`
+	SHA1       string `yaml:"sha1,omitempty"`
+	// JDK is the version of Java required by the artifact and its
+	// dependencies, the newest version of their classes, recorded for the
+	// root artifact.
+	JDK                  string       `yaml:"jdk,omitempty"`
+	Dependencies         []mavenSpell `yaml:"dependencies,omitempty"`
+	ExternalDependencies Config       `yaml:"external_dependencies,omitempty"`
+}
+
+// Function used to check if two mavenSpell objects are equal.
+// It takes in an equatable interface as a parameter and returns a boolean
+// value indicating whether the two objects are equal or not.
+// The function first checks if the input parameter t is of type mavenSpell.
+//
+// If it is, it proceeds to compare the coordinates of the two objects and
+// all its other Dependencies.
+// The function returns true if the two objects are equal.
+// Otherwise, it returns false.
+func (m mavenSpell) equals(t equatable) bool {
+	o, err := types.To[mavenSpell](t)
+	if err != nil {
+		return false
+	}
+	equality := o.coordinates() == m.coordinates() &&
+		len(o.Dependencies) == len(m.Dependencies)
+	if !equality {
+		return false
+	}
+	for i := range o.Dependencies {
+		equality = equality &&
+			o.Dependencies[i].equals(m.Dependencies[i])
+	}
+	return equality
+}
+
+// coordinates returns groupId:artifactId:version, followed by the classifier
+// when there is one.
+func (m mavenSpell) coordinates() string {
+	coordinates := m.GroupID + ":" + m.ArtifactID + ":" + m.Version
+	if m.Classifier != "" {
+		coordinates += ":" + m.Classifier
+	}
+	return coordinates
+}
+
+// jar returns the path of the jar of the artifact in the repository layout.
+func (m mavenSpell) jar() string {
+	return mavenArtifactPath(m.GroupID, m.ArtifactID, m.Version, m.Classifier, "jar")
+}
+
+// closure returns the spell itself followed by its dependencies, the order
+// of the classpath.
+func (m mavenSpell) closure() []mavenSpell {
+	return append([]mavenSpell{m}, m.Dependencies...)
+}
+
+// repository returns the URL of the Maven repository of the spell.
+func (m mavenSpell) repository() string {
+	if m.Repository == "" {
+		return mavenDefaultRepository
+	}
+	return m.Repository
+}
+
+// mavenSpellRepository returns the repository recorded in a spell, empty
+// for Maven Central.
+func mavenSpellRepository(repository string) string {
+	if repository == mavenDefaultRepository {
+		return ""
+	}
+	return repository
+}
+
+// parseMavenCoordinates parses groupId:artifactId:version, optionally
+// followed by a classifier.
+func parseMavenCoordinates(coordinates string) (mavenSpell, error) {
+	parts := strings.Split(coordinates, ":")
+	if (len(parts) != 3 && len(parts) != 4) || slices.Contains(parts, "") {
+		return mavenSpell{}, fmt.Errorf("\"%s\" is not groupId:artifactId:version.",
+			coordinates)
+	}
+	spell := mavenSpell{GroupID: parts[0], ArtifactID: parts[1], Version: parts[2]}
+	if len(parts) == 4 {
+		spell.Classifier = parts[3]
+	}
+	return spell, nil
+}
+
+// mavenRepository returns the directory of the local repository in the
+// project.
+func mavenRepository() (string, error) {
+	project, err := project.ProjectPath()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(*project, mavenModuleName), nil
+}
+
+// sha1File returns the hex encoded SHA-1 digest of a file.
+func sha1File(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha1.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// downloadJar downloads the jar of the spell into the local repository,
+// unless it is already there, and checks its SHA-1.
+func (m mavenSpell) downloadJar(repository string) error {
+	file := path.Join(repository, m.jar())
+	if hash, err := sha1File(file); err == nil && (m.SHA1 == "" || hash == m.SHA1) {
+		return nil
+	}
+	_, _, err := downloadURL(strings.TrimSuffix(m.repository(), "/")+"/"+m.jar(), file, "")
+	if err != nil {
+		return err
+	}
+	hash, err := sha1File(file)
+	if err != nil {
+		return err
+	}
+	if m.SHA1 != "" && hash != m.SHA1 {
+		os.Remove(file)
+		return fmt.Errorf("%s has sha1 %s, expected %s.", m.jar(), hash, m.SHA1)
+	}
+	return nil
+}
+
+// savePOMs saves the POM of the artifact and the ones of its parents into
+// the local repository, so that it can be used by Maven offline. The parents
+// are searched as when resolving.
+func (m mavenSpell) savePOMs(repository string) error {
+	groupID, artifactID, version := m.GroupID, m.ArtifactID, m.Version
+	resolver := newMavenResolver(m.repository())
+	declared := []string{}
+	for {
+		file := path.Join(repository, mavenArtifactPath(groupID, artifactID, version, "", "pom"))
+		content, err := os.ReadFile(file)
+		if err != nil {
+			content, _, err = resolver.fetchFrom(resolver.repositories(declared),
+				mavenArtifactPath(groupID, artifactID, version, "", "pom"))
+			if err != nil {
+				return err
+			}
+			if err = os.MkdirAll(path.Dir(file), 0755); err != nil {
+				return err
+			}
+			if err = os.WriteFile(file, content, 0644); err != nil {
+				return err
+			}
+		}
+		pom := &mavenPOM{}
+		if err = xml.Unmarshal(content, pom); err != nil || pom.Parent == nil {
+			return err
+		}
+		declared = append(declared, pom.Repositories...)
+		groupID, artifactID, version = pom.Parent.GroupID, pom.Parent.ArtifactID,
+			pom.Parent.Version
+	}
+}
+
+// mavenClassVersion returns the version of Java targeted by the classes of
+// the jar, the newest of them, or zero when it has no classes.
+func mavenClassVersion(jar string) (int, error) {
+	archive, err := zip.OpenReader(jar)
+	if err != nil {
+		return 0, fmt.Errorf("reading %s: %v", path.Base(jar), err)
+	}
+	defer archive.Close()
+	version := 0
+	for _, entry := range archive.File {
+		// The classes of other versions of a multi-release jar are only
+		// loaded by the newer runtimes.
+		if !strings.HasSuffix(entry.Name, ".class") ||
+			strings.HasPrefix(entry.Name, "META-INF/") ||
+			path.Base(entry.Name) == "module-info.class" {
+			continue
+		}
+		class, err := entry.Open()
+		if err != nil {
+			return 0, err
+		}
+		header := make([]byte, 8)
+		_, err = io.ReadFull(class, header)
+		class.Close()
+		if err != nil || binary.BigEndian.Uint32(header) != 0xCAFEBABE {
+			continue
+		}
+		version = max(version, int(binary.BigEndian.Uint16(header[6:]))-44)
+	}
+	return version, nil
+}
+
+// mavenJavaCandidates returns the packages of the Java runtimes of the
+// package manager, by order of preference, for classes of the version: the
+// JDKs with long-term support running them, the oldest first, then the
+// default runtime.
+func mavenJavaCandidates(manager string, version int) []string {
+	candidates := []string{}
+	for _, jdk := range mavenJDKs {
+		if jdk >= version {
+			candidates = append(candidates, mavenJavaPackages[manager](jdk))
+		}
+	}
+	if javaPackage, ok := mavenDefaultJavaPackages[manager]; ok {
+		candidates = append(candidates, javaPackage)
+	}
+	return candidates
+}
+
+// mavenCommitJava commits into the config the first Java runtime of the
+// package manager, for classes of the version, which can be resolved.
+func mavenCommitJava(manager string, config *Config, version int) error {
+	var err error
+	for _, javaPackage := range mavenJavaCandidates(manager, version) {
+		if err = commitSystemPackage(manager, config, javaPackage); err == nil {
+			return nil
+		}
+		logger.Get().Printf("[maven/bareRun]: %s cannot be used: %v", javaPackage, err)
+	}
+	return fmt.Errorf("No Java %d runtime is packaged for %s: %v", version, manager, err)
+}
+
+// mavenMainClass returns the Main-Class of the manifest of the jar.
+func mavenMainClass(jar string) (string, error) {
+	archive, err := zip.OpenReader(jar)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %v", path.Base(jar), err)
+	}
+	defer archive.Close()
+	manifest, err := archive.Open("META-INF/MANIFEST.MF")
+	if err != nil {
+		return "", nil
+	}
+	defer manifest.Close()
+	scanner := bufio.NewScanner(manifest)
+	for scanner.Scan() {
+		if mainClass, found := strings.CutPrefix(scanner.Text(), "Main-Class:"); found {
+			return strings.TrimSpace(mainClass), nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// mavenJava returns the java run by the launchers, the one of JAVA_HOME when
+// it is set.
+func mavenJava() string {
+	if home := os.Getenv("JAVA_HOME"); home != "" {
+		return path.Join(home, "bin", "java")
+	}
+	return "java"
+}
+
+// javaVersion returns the major version of the java run by the launchers.
+func javaVersion() (int, error) {
+	output, err := exec.Command(mavenJava(), "-XshowSettings:properties", "-version").CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("running java: %v", err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		name, value, found := strings.Cut(line, "=")
+		if !found || strings.TrimSpace(name) != "java.specification.version" {
+			continue
+		}
+		value = strings.TrimPrefix(strings.TrimSpace(value), "1.")
+		return strconv.Atoi(value)
+	}
+	return 0, errors.New("The version of java is unknown.")
+}
+
+// BulkSave implements Module.
+func (m *mavenModule) BulkSave(config *Config) error {
+	for _, ms := range config.Maven {
+		err := m.Save(ms)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CliConfig implements Module.
+func (m *mavenModule) CliConfig(config *Config) *cobra.Command {
+	command := &cobra.Command{
+		Args:    m.cobraArgs(),
+		Example: mavenModuleExample,
+		Run:     m.cobraRun(config),
+		Short:   mavenModuleShort,
+		Use:     mavenModuleName,
+	}
+	command.Flags().String("repository", "",
+		"Maven repository searched before the ones declared by the POMs and Maven Central.")
+	return command
+}
+
+// Function used to validate the arguments passed to the maven command.
+// If no coordinates are passed, it returns an error.
+// Otherwise it returns nil.
+//
+// Intended to be used by cobra.
+func (m *mavenModule) cobraArgs() func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return fmt.Errorf("%s module requires at least one argument.",
+				mavenModuleName)
+		}
+		_, err := parseMavenCoordinates(args[0])
+		return err
+	}
+}
+
+// Function used to run the module from the command line.
+// It serves as an entry point to the bare run of the mavenModule.
+//
+// Intended to be used by cobra.
+func (m *mavenModule) cobraRun(config *Config) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, args []string) {
+		spell, err := parseMavenCoordinates(args[0])
+		if err != nil {
+			logger.Get().Fatal(err)
+		}
+		spell.Repository, _ = cmd.Flags().GetString("repository")
+		spell, err = m.bareRun(spell)
+		if err != nil {
+			logger.Get().Fatal(err)
+		}
+		err = m.Commit(config, spell)
+		if err != nil && err != ErrAlreadyPresent {
+			logger.Get().Fatal(err)
+		}
+	}
+}
+
+// bareRun resolves the transitive dependencies of the artifact and records
+// the repository and the SHA-1 of every jar. The jars are downloaded into
+// the local repository to find the version of Java they require. A runtime
+// running this version, or the default one, is recorded as an external
+// dependency.
+func (*mavenModule) bareRun(s mavenSpell) (mavenSpell, error) {
+	if spell := cache.Retrieve(mavenModuleName+"bare", s.coordinates()); spell != nil {
+		newSpell, err := types.To[mavenSpell](spell)
+		if err == nil {
+			return *newSpell, nil
+		}
+	}
+	resolver := newMavenResolver(s.Repository)
+	resolved, err := resolver.resolve(s.GroupID, s.ArtifactID, s.Version)
+	if err != nil {
+		return mavenSpell{}, err
+	}
+	root, err := resolver.pom(s.GroupID, s.ArtifactID, s.Version, nil)
+	if err != nil {
+		return mavenSpell{}, err
+	}
+	spell := s
+	spell.Repository = mavenSpellRepository(root.repository)
+	for _, dependency := range resolved {
+		spell.Dependencies = append(spell.Dependencies, mavenSpell{
+			GroupID:    dependency.GroupID,
+			ArtifactID: dependency.ArtifactID,
+			Version:    dependency.Version,
+			Classifier: dependency.Classifier,
+			Repository: mavenSpellRepository(dependency.repository),
+		})
+	}
+	repository, err := mavenRepository()
+	if err != nil {
+		return mavenSpell{}, err
+	}
+	classVersion := 0
+	for i, artifact := range spell.closure() {
+		checksum, err := resolver.fetch(artifact.repository(), artifact.jar()+".sha1")
+		if err != nil {
+			return mavenSpell{}, err
+		}
+		fields := strings.Fields(string(checksum))
+		if len(fields) == 0 {
+			return mavenSpell{}, fmt.Errorf("%s has an empty sha1.", artifact.jar())
+		}
+		artifact.SHA1 = strings.ToLower(fields[0])
+		if err = artifact.downloadJar(repository); err != nil {
+			return mavenSpell{}, err
+		}
+		version, err := mavenClassVersion(path.Join(repository, artifact.jar()))
+		if err != nil {
+			return mavenSpell{}, err
+		}
+		classVersion = max(classVersion, version)
+		if i == 0 {
+			spell.SHA1 = artifact.SHA1
+		} else {
+			spell.Dependencies[i-1].SHA1 = artifact.SHA1
+		}
+	}
+	if classVersion > 0 {
+		spell.JDK = strconv.Itoa(classVersion)
+		for manager := range mavenJavaPackages {
+			if _, ok := Modules[manager]; !ok {
+				continue
+			}
+			err = mavenCommitJava(manager, &spell.ExternalDependencies, classVersion)
+			if err != nil {
+				return mavenSpell{}, err
+			}
+		}
+	}
+	_ = cache.Insert(mavenModuleName+"bare", s.coordinates(), spell)
+	return spell, nil
+}
+
+// Commit implements Module.
+func (*mavenModule) Commit(config *Config, result any) error {
+	newEntry, err := types.To[mavenSpell](result)
+	if err != nil {
+		return ErrConverting
+	}
+	if Contains(config.Maven, *newEntry) {
+		return ErrAlreadyPresent
+	}
+	config.Maven = append(config.Maven, *newEntry)
+	return nil
+}
+
+// Save implements Module. The jars and the POMs of the artifact and its
+// dependencies are saved into the local repository.
+func (*mavenModule) Save(anySpell any) error {
+	spell, err := types.To[mavenSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	err = DeepSave(&spell.ExternalDependencies)
+	if err != nil {
+		return err
+	}
+	repository, err := mavenRepository()
+	if err != nil {
+		return err
+	}
+	for _, artifact := range spell.closure() {
+		if cache.Retrieve(mavenModuleName+"save", artifact.coordinates()) != nil {
+			continue
+		}
+		if err = artifact.downloadJar(repository); err != nil {
+			return err
+		}
+		if err = artifact.savePOMs(repository); err != nil {
+			return err
+		}
+		_ = cache.Insert(mavenModuleName+"save", artifact.coordinates(), true)
+	}
+	return nil
+}
+
+// Apply implements Module. The classpath of the artifact is written into the
+// prefix of the project, along with a launcher when the jar has a main
+// class.
+func (*mavenModule) Apply(anySpell any) error {
+	spell, err := types.To[mavenSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	err = DeepApply(&spell.ExternalDependencies)
+	if err != nil {
+		return err
+	}
+	if spell.JDK != "" {
+		required, err := strconv.Atoi(spell.JDK)
+		if err != nil {
+			return fmt.Errorf("%w maven jdk %s is not a version.", ErrInvalidSpell,
+				spell.JDK)
+		}
+		version, err := javaVersion()
+		if err != nil {
+			return err
+		}
+		if version < required {
+			return fmt.Errorf("%s requires Java %d, found Java %d.",
+				spell.coordinates(), required, version)
+		}
+	}
+	repository, err := mavenRepository()
+	if err != nil {
+		return err
+	}
+	classpath := []string{}
+	for _, artifact := range spell.closure() {
+		jar := path.Join(repository, artifact.jar())
+		if _, err := os.Stat(jar); errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s has not been saved, run `credo save` first.",
+				artifact.coordinates())
+		}
+		classpath = append(classpath, jar)
+	}
+	prefix := path.Join(path.Dir(repository), urlPrefixDirectory)
+	name := spell.ArtifactID + "-" + spell.Version
+	classpathFile := path.Join(prefix, "share", "java", name+".classpath")
+	if err = os.MkdirAll(path.Dir(classpathFile), 0755); err != nil {
+		return err
+	}
+	err = os.WriteFile(classpathFile, []byte(strings.Join(classpath, ":")+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	mainClass, err := mavenMainClass(classpath[0])
+	if err != nil {
+		return err
+	}
+	if mainClass == "" {
+		logger.Get().Printf("[maven/apply]: the classpath of %s is in %s.",
+			spell.coordinates(), classpathFile)
+		return nil
+	}
+	launcher := path.Join(prefix, "bin", spell.ArtifactID)
+	if err = os.MkdirAll(path.Dir(launcher), 0755); err != nil {
+		return err
+	}
+	err = os.WriteFile(launcher, []byte(mavenLauncher(classpath, mainClass)), 0755)
+	if err != nil {
+		return err
+	}
+	logger.Get().Printf("[maven/apply]: %s can be run with %s.",
+		spell.coordinates(), launcher)
+	return nil
+}
+
+// mavenLauncher returns the shell script running the main class.
+func mavenLauncher(classpath []string, mainClass string) string {
+	return fmt.Sprintf(`#!/bin/sh
+# This file is automatically generated by CREDO.
+JAVA="${JAVA_HOME:+$JAVA_HOME/bin/}java"
+exec "$JAVA" $JAVA_OPTS -cp "%s" %s "$@"
+`, strings.Join(classpath, ":"), mainClass)
+}
+
+// BulkApply implements Module.
+func (m *mavenModule) BulkApply(config *Config) error {
+	for _, ms := range config.Maven {
+		err := m.Apply(ms)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,413 @@
+package modules
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Default Maven repository, Maven Central.
+const mavenDefaultRepository = "https://repo.maven.apache.org/maven2"
+
+// Scopes of the dependencies needed at run time.
+var mavenScopes = []string{"", "compile", "runtime"}
+
+// Error of a file missing from a Maven repository.
+var errMavenNotFound = errors.New("not found")
+
+// Properties referenced in the POMs, such as ${project.version}.
+var mavenProperty = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// mavenProperties are the properties of a POM, read from any element.
+type mavenProperties map[string]string
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (p *mavenProperties) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	*p = mavenProperties{}
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			var value string
+			if err = d.DecodeElement(&value, &element); err != nil {
+				return err
+			}
+			(*p)[element.Name.Local] = strings.TrimSpace(value)
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// mavenExclusion excludes the transitive dependencies of a dependency.
+type mavenExclusion struct {
+	GroupID    string `xml:"groupId"`
+	ArtifactID string `xml:"artifactId"`
+}
+
+// mavenDependency is a dependency declared by a POM.
+type mavenDependency struct {
+	GroupID    string           `xml:"groupId"`
+	ArtifactID string           `xml:"artifactId"`
+	Version    string           `xml:"version"`
+	Type       string           `xml:"type"`
+	Classifier string           `xml:"classifier"`
+	Scope      string           `xml:"scope"`
+	Optional   string           `xml:"optional"`
+	Exclusions []mavenExclusion `xml:"exclusions>exclusion"`
+	// repository is the repository the POM of the dependency comes from.
+	repository string
+}
+
+// key returns the identity of the dependency, regardless of its version.
+func (d mavenDependency) key() string {
+	return d.GroupID + ":" + d.ArtifactID + ":" + d.Classifier
+}
+
+// mavenPOM is the part of a POM used to resolve dependencies.
+type mavenPOM struct {
+	GroupID    string `xml:"groupId"`
+	ArtifactID string `xml:"artifactId"`
+	Version    string `xml:"version"`
+	Packaging  string `xml:"packaging"`
+	Parent     *struct {
+		GroupID    string `xml:"groupId"`
+		ArtifactID string `xml:"artifactId"`
+		Version    string `xml:"version"`
+	} `xml:"parent"`
+	Properties   mavenProperties   `xml:"properties"`
+	Management   []mavenDependency `xml:"dependencyManagement>dependencies>dependency"`
+	Dependencies []mavenDependency `xml:"dependencies>dependency"`
+	// Repositories are the URLs of the repositories declared by the POM and
+	// its parents.
+	Repositories []string `xml:"repositories>repository>url"`
+	// repository is the repository the POM comes from.
+	repository string
+}
+
+// mavenArtifactPath returns the path of a file of the artifact in the
+// repository layout, such as org/example/lib/1.0/lib-1.0.jar.
+func mavenArtifactPath(groupID, artifactID, version, classifier, extension string) string {
+	name := artifactID + "-" + version
+	if classifier != "" {
+		name += "-" + classifier
+	}
+	return strings.Join([]string{strings.ReplaceAll(groupID, ".", "/"), artifactID,
+		version, name + "." + extension}, "/")
+}
+
+// mavenResolver resolves the transitive dependencies of an artifact from the
+// POMs of the repositories, as Maven does: the nearest declaration of an
+// artifact wins, and the dependency management of the root overrides the
+// versions of the transitive dependencies.
+type mavenResolver struct {
+	// repository is searched first, before the repositories declared by the
+	// POMs and Maven Central. It may be empty.
+	repository string
+	// fetch returns the file of the repository at the path.
+	fetch func(repository string, path string) ([]byte, error)
+	// Effective POMs, by coordinates.
+	poms map[string]*mavenPOM
+}
+
+// newMavenResolver returns a resolver searching the repository first.
+func newMavenResolver(repository string) *mavenResolver {
+	return &mavenResolver{repository: repository, fetch: mavenFetch,
+		poms: map[string]*mavenPOM{}}
+}
+
+// mavenFetch returns the file of the repository at the path. The error
+// wraps errMavenNotFound when the repository does not have it.
+func mavenFetch(repository string, path string) ([]byte, error) {
+	response, err := httpClient.Get(strings.TrimSuffix(repository, "/") + "/" + path)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %v", path, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("fetching %s from %s: %w", path, repository, errMavenNotFound)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", path, response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// repositories returns the repositories searched, in order: the one of the
+// resolver, the ones declared by the POMs and Maven Central. The declared
+// URLs which are not http are ignored, such as the ones referencing an
+// unknown property.
+func (r *mavenResolver) repositories(declared []string) []string {
+	repositories := []string{}
+	candidates := append(append([]string{r.repository}, declared...), mavenDefaultRepository)
+	for _, repository := range candidates {
+		repository = strings.TrimSuffix(strings.TrimSpace(repository), "/")
+		if (strings.HasPrefix(repository, "https://") ||
+			strings.HasPrefix(repository, "http://")) &&
+			!slices.Contains(repositories, repository) {
+			repositories = append(repositories, repository)
+		}
+	}
+	return repositories
+}
+
+// fetchFrom returns the file at the path from the first of the repositories
+// having it, along with this repository.
+func (r *mavenResolver) fetchFrom(repositories []string, path string) ([]byte, string, error) {
+	for _, repository := range repositories {
+		content, err := r.fetch(repository, path)
+		if err == nil {
+			return content, repository, nil
+		}
+		if !errors.Is(err, errMavenNotFound) {
+			return nil, "", err
+		}
+	}
+	return nil, "", fmt.Errorf("%s is in none of the repositories %s.", path,
+		strings.Join(repositories, ", "))
+}
+
+// interpolate replaces the properties referenced in the value.
+func (p *mavenPOM) interpolate(value string) string {
+	for range 10 {
+		replaced := mavenProperty.ReplaceAllStringFunc(value, func(reference string) string {
+			name := strings.TrimSuffix(strings.TrimPrefix(reference, "${"), "}")
+			switch strings.TrimPrefix(strings.TrimPrefix(name, "project."), "pom.") {
+			case "groupId":
+				return p.GroupID
+			case "artifactId":
+				return p.ArtifactID
+			case "version":
+				return p.Version
+			case "parent.groupId":
+				if p.Parent != nil {
+					return p.Parent.GroupID
+				}
+			case "parent.version":
+				if p.Parent != nil {
+					return p.Parent.Version
+				}
+			}
+			if property, ok := p.Properties[name]; ok {
+				return property
+			}
+			return reference
+		})
+		if replaced == value {
+			break
+		}
+		value = replaced
+	}
+	return value
+}
+
+// interpolateDependencies replaces the properties referenced in the
+// coordinates of the dependencies.
+func (p *mavenPOM) interpolateDependencies(dependencies []mavenDependency) {
+	for i := range dependencies {
+		d := &dependencies[i]
+		d.GroupID = p.interpolate(d.GroupID)
+		d.ArtifactID = p.interpolate(d.ArtifactID)
+		d.Version = p.interpolate(d.Version)
+		d.Classifier = p.interpolate(d.Classifier)
+		d.Scope = p.interpolate(d.Scope)
+		d.Optional = p.interpolate(d.Optional)
+	}
+}
+
+// pom returns the effective POM of the artifact: its parents merged in,
+// its properties interpolated and its imported dependency management merged
+// in. It is searched in the repositories declared by the POMs referencing
+// it, as well as in the ones of the resolver.
+func (r *mavenResolver) pom(groupID, artifactID, version string, declared []string) (*mavenPOM, error) {
+	coordinates := groupID + ":" + artifactID + ":" + version
+	if pom, ok := r.poms[coordinates]; ok {
+		return pom, nil
+	}
+	content, repository, err := r.fetchFrom(r.repositories(declared),
+		mavenArtifactPath(groupID, artifactID, version, "", "pom"))
+	if err != nil {
+		return nil, err
+	}
+	pom := &mavenPOM{repository: repository}
+	if err = xml.Unmarshal(content, pom); err != nil {
+		return nil, fmt.Errorf("parsing the POM of %s: %v", coordinates, err)
+	}
+	if pom.Properties == nil {
+		pom.Properties = mavenProperties{}
+	}
+	if pom.Parent != nil {
+		parent, err := r.pom(pom.Parent.GroupID, pom.Parent.ArtifactID, pom.Parent.Version,
+			append(slices.Clone(declared), pom.Repositories...))
+		if err != nil {
+			return nil, err
+		}
+		if pom.GroupID == "" {
+			pom.GroupID = parent.GroupID
+		}
+		if pom.Version == "" {
+			pom.Version = parent.Version
+		}
+		for name, value := range parent.Properties {
+			if _, ok := pom.Properties[name]; !ok {
+				pom.Properties[name] = value
+			}
+		}
+		pom.Management = mavenMergeManagement(pom.Management, parent.Management)
+		pom.Dependencies = mavenMergeManagement(pom.Dependencies, parent.Dependencies)
+		for _, url := range parent.Repositories {
+			if !slices.Contains(pom.Repositories, url) {
+				pom.Repositories = append(pom.Repositories, url)
+			}
+		}
+	}
+	for i := range pom.Repositories {
+		pom.Repositories[i] = pom.interpolate(pom.Repositories[i])
+	}
+	pom.interpolateDependencies(pom.Management)
+	pom.interpolateDependencies(pom.Dependencies)
+	// The declarations take precedence over the imported ones.
+	imports := []mavenDependency{}
+	management := []mavenDependency{}
+	for _, managed := range pom.Management {
+		if managed.Scope == "import" {
+			imports = append(imports, managed)
+		} else {
+			management = append(management, managed)
+		}
+	}
+	for _, imported := range imports {
+		bom, err := r.pom(imported.GroupID, imported.ArtifactID, imported.Version,
+			append(slices.Clone(declared), pom.Repositories...))
+		if err != nil {
+			return nil, err
+		}
+		management = mavenMergeManagement(management, bom.Management)
+	}
+	pom.Management = management
+	r.poms[coordinates] = pom
+	return pom, nil
+}
+
+// mavenMergeManagement returns the declarations followed by the inherited
+// ones which they do not override.
+func mavenMergeManagement(declared []mavenDependency, inherited []mavenDependency) []mavenDependency {
+	merged := slices.Clone(declared)
+	for _, dependency := range inherited {
+		if !slices.ContainsFunc(merged, func(d mavenDependency) bool {
+			return d.key() == dependency.key()
+		}) {
+			merged = append(merged, dependency)
+		}
+	}
+	return merged
+}
+
+// mavenManaged returns the managed declaration of the dependency.
+func mavenManaged(management []mavenDependency, dependency mavenDependency) (mavenDependency, bool) {
+	for _, managed := range management {
+		if managed.key() == dependency.key() {
+			return managed, true
+		}
+	}
+	return mavenDependency{}, false
+}
+
+// mavenVersion returns the version selected by a version requirement: the
+// version itself, or the lower bound of a range including it.
+func mavenVersion(requirement string) (string, error) {
+	if !strings.HasPrefix(requirement, "[") && !strings.HasPrefix(requirement, "(") {
+		return requirement, nil
+	}
+	lower, _, _ := strings.Cut(strings.TrimPrefix(requirement, "["), ",")
+	lower = strings.TrimSuffix(strings.TrimSpace(lower), "]")
+	if strings.HasPrefix(requirement, "(") || lower == "" {
+		return "", fmt.Errorf("Unsupported version range: %s", requirement)
+	}
+	return lower, nil
+}
+
+// mavenExcluded reports whether the exclusions exclude the dependency.
+func mavenExcluded(exclusions []mavenExclusion, dependency mavenDependency) bool {
+	return slices.ContainsFunc(exclusions, func(e mavenExclusion) bool {
+		return (e.GroupID == "*" || e.GroupID == dependency.GroupID) &&
+			(e.ArtifactID == "*" || e.ArtifactID == dependency.ArtifactID)
+	})
+}
+
+// resolve returns the artifacts needed at run time by the artifact, in
+// breadth first order, the artifact excluded. The dependencies are searched
+// in the repositories declared by the POMs on their path from the artifact.
+func (r *mavenResolver) resolve(groupID, artifactID, version string) ([]mavenDependency, error) {
+	root, err := r.pom(groupID, artifactID, version, nil)
+	if err != nil {
+		return nil, err
+	}
+	type node struct {
+		pom          *mavenPOM
+		exclusions   []mavenExclusion
+		repositories []string
+	}
+	queue := []node{{pom: root, repositories: root.Repositories}}
+	selected := map[string]bool{
+		mavenDependency{GroupID: groupID, ArtifactID: artifactID}.key(): true,
+	}
+	resolved := []mavenDependency{}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, dependency := range current.pom.Dependencies {
+			if managed, ok := mavenManaged(root.Management, dependency); ok {
+				// The root manages its transitive dependencies.
+				if (managed.Version != "" && current.pom != root) || dependency.Version == "" {
+					dependency.Version = managed.Version
+				}
+				if dependency.Scope == "" {
+					dependency.Scope = managed.Scope
+				}
+			} else if managed, ok := mavenManaged(current.pom.Management, dependency); ok {
+				if dependency.Version == "" {
+					dependency.Version = managed.Version
+				}
+				if dependency.Scope == "" {
+					dependency.Scope = managed.Scope
+				}
+			}
+			if !slices.Contains(mavenScopes, dependency.Scope) ||
+				dependency.Optional == "true" ||
+				(dependency.Type != "" && dependency.Type != "jar" && dependency.Type != "bundle") ||
+				mavenExcluded(current.exclusions, dependency) ||
+				selected[dependency.key()] {
+				continue
+			}
+			selected[dependency.key()] = true
+			version, err := mavenVersion(dependency.Version)
+			if err != nil {
+				return nil, fmt.Errorf("resolving %s: %v", dependency.key(), err)
+			}
+			if version == "" {
+				return nil, fmt.Errorf("%s has no version.", dependency.key())
+			}
+			dependency.Version = version
+			pom, err := r.pom(dependency.GroupID, dependency.ArtifactID, version,
+				current.repositories)
+			if err != nil {
+				return nil, err
+			}
+			dependency.repository = pom.repository
+			resolved = append(resolved, dependency)
+			queue = append(queue, node{pom: pom,
+				exclusions:   append(slices.Clone(current.exclusions), dependency.Exclusions...),
+				repositories: append(slices.Clone(current.repositories), pom.Repositories...)})
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,238 @@
+package modules
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mavenTestFetch returns a fetch function serving the files, by repository
+// and path.
+func mavenTestFetch(repositories map[string]map[string]string) func(string, string) ([]byte, error) {
+	return func(repository string, path string) ([]byte, error) {
+		file, ok := repositories[repository][path]
+		if !ok {
+			return nil, fmt.Errorf("fetching %s: %w", path, errMavenNotFound)
+		}
+		return []byte(file), nil
+	}
+}
+
+// mavenTestPOM returns a POM with the elements.
+func mavenTestPOM(elements string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<project xmlns="http://maven.apache.org/POM/4.0.0">` + elements + `</project>`
+}
+
+func Test_mavenResolverResolve(t *testing.T) {
+	poms := map[string]string{
+		"org/example/parent/1/parent-1.pom": mavenTestPOM(`
+			<groupId>org.example</groupId><artifactId>parent</artifactId><version>1</version>
+			<properties><guava.version>32.1.3-jre</guava.version></properties>
+			<dependencyManagement><dependencies>
+				<dependency><groupId>com.google.guava</groupId><artifactId>guava</artifactId><version>${guava.version}</version></dependency>
+				<dependency><groupId>org.example</groupId><artifactId>bom</artifactId><version>2</version><type>pom</type><scope>import</scope></dependency>
+			</dependencies></dependencyManagement>`),
+		"org/example/bom/2/bom-2.pom": mavenTestPOM(`
+			<groupId>org.example</groupId><artifactId>bom</artifactId><version>2</version>
+			<dependencyManagement><dependencies>
+				<dependency><groupId>org.example</groupId><artifactId>util</artifactId><version>2.0</version></dependency>
+				<dependency><groupId>com.google.guava</groupId><artifactId>guava</artifactId><version>1.0</version></dependency>
+			</dependencies></dependencyManagement>`),
+		"org/example/tool/1.0/tool-1.0.pom": mavenTestPOM(`
+			<parent><groupId>org.example</groupId><artifactId>parent</artifactId><version>1</version></parent>
+			<artifactId>tool</artifactId><version>1.0</version>
+			<dependencies>
+				<dependency><groupId>${project.groupId}</groupId><artifactId>core</artifactId><version>${project.version}</version>
+					<exclusions><exclusion><groupId>org.unwanted</groupId><artifactId>*</artifactId></exclusion></exclusions></dependency>
+				<dependency><groupId>org.example</groupId><artifactId>util</artifactId></dependency>
+				<dependency><groupId>junit</groupId><artifactId>junit</artifactId><version>4.13</version><scope>test</scope></dependency>
+				<dependency><groupId>org.optional</groupId><artifactId>extra</artifactId><version>1</version><optional>true</optional></dependency>
+			</dependencies>`),
+		"org/example/core/1.0/core-1.0.pom": mavenTestPOM(`
+			<groupId>org.example</groupId><artifactId>core</artifactId><version>1.0</version>
+			<dependencies>
+				<dependency><groupId>com.google.guava</groupId><artifactId>guava</artifactId><version>[20.0,)</version></dependency>
+				<dependency><groupId>org.example</groupId><artifactId>util</artifactId><version>1.0</version></dependency>
+				<dependency><groupId>org.unwanted</groupId><artifactId>logger</artifactId><version>1</version></dependency>
+				<dependency><groupId>org.example</groupId><artifactId>provided</artifactId><version>1</version><scope>provided</scope></dependency>
+			</dependencies>`),
+		"org/example/util/2.0/util-2.0.pom": mavenTestPOM(`
+			<groupId>org.example</groupId><artifactId>util</artifactId><version>2.0</version>`),
+		"com/google/guava/guava/32.1.3-jre/guava-32.1.3-jre.pom": mavenTestPOM(`
+			<groupId>com.google.guava</groupId><artifactId>guava</artifactId><version>32.1.3-jre</version>`),
+	}
+	resolver := &mavenResolver{
+		fetch: mavenTestFetch(map[string]map[string]string{mavenDefaultRepository: poms}),
+		poms:  map[string]*mavenPOM{},
+	}
+	resolved, err := resolver.resolve("org.example", "tool", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, dependency := range resolved {
+		got = append(got, dependency.GroupID+":"+dependency.ArtifactID+":"+dependency.Version)
+	}
+	want := []string{
+		"org.example:core:1.0",
+		"org.example:util:2.0",
+		"com.google.guava:guava:32.1.3-jre",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolve() = %v, want %v", got, want)
+	}
+}
+
+func Test_mavenResolverRepositories(t *testing.T) {
+	configured := "https://maven.example.org/releases"
+	declared := "https://repository.example.org/maven2"
+	resolver := newMavenResolver(configured + "/")
+	resolver.fetch = mavenTestFetch(map[string]map[string]string{
+		configured: {
+			"org/example/tool/1.0/tool-1.0.pom": mavenTestPOM(`
+				<groupId>org.example</groupId><artifactId>tool</artifactId><version>1.0</version>
+				<properties><repository.url>` + declared + `/</repository.url></properties>
+				<repositories><repository><id>example</id><url>${repository.url}</url></repository></repositories>
+				<dependencies>
+					<dependency><groupId>org.example</groupId><artifactId>core</artifactId><version>1.0</version></dependency>
+					<dependency><groupId>com.google.guava</groupId><artifactId>guava</artifactId><version>32.1.3-jre</version></dependency>
+				</dependencies>`),
+		},
+		declared: {
+			"org/example/core/1.0/core-1.0.pom": mavenTestPOM(`
+				<groupId>org.example</groupId><artifactId>core</artifactId><version>1.0</version>
+				<dependencies>
+					<dependency><groupId>org.example</groupId><artifactId>util</artifactId><version>1.0</version></dependency>
+				</dependencies>`),
+			"org/example/util/1.0/util-1.0.pom": mavenTestPOM(`
+				<groupId>org.example</groupId><artifactId>util</artifactId><version>1.0</version>`),
+		},
+		mavenDefaultRepository: {
+			"com/google/guava/guava/32.1.3-jre/guava-32.1.3-jre.pom": mavenTestPOM(`
+				<groupId>com.google.guava</groupId><artifactId>guava</artifactId><version>32.1.3-jre</version>`),
+		},
+	})
+	resolved, err := resolver.resolve("org.example", "tool", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, dependency := range resolved {
+		got[dependency.ArtifactID] = dependency.repository
+	}
+	want := map[string]string{"core": declared, "guava": mavenDefaultRepository,
+		"util": declared}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolve() repositories = %v, want %v", got, want)
+	}
+	if _, err = resolver.resolve("org.example", "missing", "1.0"); err == nil ||
+		!strings.Contains(err.Error(), "none of the repositories") {
+		t.Errorf("resolve() = %v, want a missing POM", err)
+	}
+}
+
+func Test_mavenVersion(t *testing.T) {
+	tests := []struct {
+		requirement string
+		want        string
+		wantErr     bool
+	}{
+		{"1.0", "1.0", false},
+		{"[1.0]", "1.0", false},
+		{"[1.0,2.0)", "1.0", false},
+		{"(1.0,2.0)", "", true},
+		{"[,2.0]", "", true},
+	}
+	for _, tt := range tests {
+		got, err := mavenVersion(tt.requirement)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("mavenVersion(%s) = %v, %v, want %v", tt.requirement, got, err, tt.want)
+		}
+	}
+}
+
+// mavenTestJar writes a jar with the files, by name.
+func mavenTestJar(t *testing.T, file string, files map[string][]byte) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	writer := zip.NewWriter(f)
+	for name, content := range files {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write(content)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_mavenJar(t *testing.T) {
+	jar := filepath.Join(t.TempDir(), "tool-1.0.jar")
+	mavenTestJar(t, jar, map[string][]byte{
+		"META-INF/MANIFEST.MF":                        []byte("Manifest-Version: 1.0\r\nMain-Class: org.example.Main\r\n"),
+		"org/example/Main.class":                      {0xCA, 0xFE, 0xBA, 0xBE, 0, 0, 0, 55},
+		"org/example/Old.class":                       {0xCA, 0xFE, 0xBA, 0xBE, 0, 0, 0, 52},
+		"META-INF/versions/21/org/example/Main.class": {0xCA, 0xFE, 0xBA, 0xBE, 0, 0, 0, 65},
+		"module-info.class":                           {0xCA, 0xFE, 0xBA, 0xBE, 0, 0, 0, 65},
+	})
+	version, err := mavenClassVersion(jar)
+	if err != nil || version != 11 {
+		t.Errorf("mavenClassVersion() = %v, %v, want 11", version, err)
+	}
+	candidates := mavenJavaCandidates("apt", 12)
+	want := []string{"openjdk-17-jre-headless", "openjdk-21-jre-headless",
+		"openjdk-25-jre-headless", "default-jre-headless"}
+	if !reflect.DeepEqual(candidates, want) {
+		t.Errorf("mavenJavaCandidates(apt, 12) = %v, want %v", candidates, want)
+	}
+	if candidates := mavenJavaCandidates("dnf", 8); candidates[0] != "java-1.8.0-openjdk-headless" {
+		t.Errorf("mavenJavaCandidates(dnf, 8) = %v", candidates)
+	}
+	mainClass, err := mavenMainClass(jar)
+	if err != nil || mainClass != "org.example.Main" {
+		t.Errorf("mavenMainClass() = %v, %v, want org.example.Main", mainClass, err)
+	}
+	launcher := mavenLauncher([]string{"/a.jar", "/b.jar"}, mainClass)
+	if !strings.Contains(launcher, `-cp "/a.jar:/b.jar" org.example.Main "$@"`) {
+		t.Errorf("mavenLauncher() = %v", launcher)
+	}
+}
+
+func Test_parseMavenCoordinates(t *testing.T) {
+	spell, err := parseMavenCoordinates("org.example:tool:1.0:linux-x86_64")
+	if err != nil || spell.coordinates() != "org.example:tool:1.0:linux-x86_64" ||
+		spell.jar() != "org/example/tool/1.0/tool-1.0-linux-x86_64.jar" {
+		t.Errorf("parseMavenCoordinates() = %v, %v", spell, err)
+	}
+	for _, coordinates := range []string{"org.example:tool", "org.example::1.0"} {
+		if _, err := parseMavenCoordinates(coordinates); err == nil {
+			t.Errorf("parseMavenCoordinates(%s) should fail", coordinates)
+		}
+	}
+}
+
+func Test_javaVersion(t *testing.T) {
+	home := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(home, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho 'Property settings:' >&2\necho '    java.specification.version = 17' >&2\n"
+	if err := os.WriteFile(filepath.Join(home, "bin", "java"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JAVA_HOME", home)
+	version, err := javaVersion()
+	if err != nil || version != 17 {
+		t.Errorf("javaVersion() = %v, %v, want 17", version, err)
+	}
+}
@@ -7,9 +7,9 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"slices"
 	"strings"
 
-	gopip "github.com/CREDOProject/go-pip"
 	"github.com/CREDOProject/go-pip/utils"
 	pythonvenv "github.com/CREDOProject/go-pythonvenv"
 	"github.com/CREDOProject/sharedutils/types"
@@ -40,26 +40,20 @@ func (m *pipModule) Apply(anySpell any) error {
 	if err != nil {
 		return fmt.Errorf("Error converting pip spell, %v", err)
 	}
-	project, err := project.ProjectPath()
+	backend, err := newPipBackend()
 	if err != nil {
 		return err
 	}
-	pipBinary, err := getPipBinary()
+	downloadPath, err := pipDownloadPath(pipCurrentTarget)
 	if err != nil {
 		return err
 	}
-	downloadPath := path.Join(*project, pipModuleName)
-	cmd, err := gopip.New(*pipBinary).
-		Install(converted.Name).
-		FindLinks(downloadPath).
-		Seal()
-	err = cmd.Run(&gopip.RunOptions{
-		Output: os.Stdout,
+	// Wheels built at save time are listed first, so that they are preferred
+	// over the source distributions they have been built from.
+	return backend.install(converted.requirement(), []string{
+		path.Join(downloadPath, pipWheelsDirectory),
+		downloadPath,
 	})
-	if err != nil {
-		return err
-	}
-	return nil
 }
 
 // BulkApply implements Module.
@@ -74,8 +68,13 @@ func (m *pipModule) BulkApply(config *Config) error {
 }
 
 type pipSpell struct {
-	Name                 string `yaml:"name"`
-	ExternalDependencies Config `yaml:"external_dependencies,omitempty"`
+	Name                 string        `yaml:"name"`
+	Markers              string        `yaml:"markers,omitempty"`
+	Artifacts            []string      `yaml:"artifacts,omitempty"`
+	Wheels               []pipWheel    `yaml:"wheels,omitempty"`
+	Toolchain            *pipToolchain `yaml:"toolchain,omitempty"`
+	Targets              []pipTarget   `yaml:"targets,omitempty"`
+	ExternalDependencies Config        `yaml:"external_dependencies,omitempty"`
 }
 
 // Function used to check if two pipSpell objects are equal.
@@ -83,7 +82,7 @@ type pipSpell struct {
 // value indicating whether the two objects are equal or not.
 // The function first checks if the input parameter t is of type pipSpell.
 //
-// If it is, it proceeds to compare the Name of the two
+// If it is, it proceeds to compare the Name and Markers of the two
 // objects.
 // The function returns true if the two objects are equal.
 // Otherwise, it returns false.
@@ -92,8 +91,17 @@ func (s pipSpell) equals(t equatable) bool {
 	if err != nil {
 		return false
 	}
-	return strings.Compare(s.Name, o.Name) == 0
+	return strings.Compare(s.Name, o.Name) == 0 &&
+		strings.Compare(s.Markers, o.Markers) == 0
+}
 
+// requirement returns the requirement specifier of the spell, as understood
+// by pip.
+func (s pipSpell) requirement() string {
+	if s.Markers == "" {
+		return s.Name
+	}
+	return fmt.Sprintf("%s; %s", s.Name, s.Markers)
 }
 
 // Commit implements Module.
@@ -109,6 +117,20 @@ func (m *pipModule) Commit(config *Config, result any) error {
 	return nil
 }
 
+// pipDownloadPath returns the directory where the artifacts for the target
+// are stored, creating it if needed.
+func pipDownloadPath(target pipTarget) (string, error) {
+	project, err := project.ProjectPath()
+	if err != nil {
+		return "", err
+	}
+	downloadPath := path.Join(*project, pipModuleName, target.directory())
+	if err = os.MkdirAll(downloadPath, 0755); err != nil {
+		return "", err
+	}
+	return downloadPath, nil
+}
+
 func setupPythonVenv(path string) (string, error) {
 	venv, err := pythonvenv.Create(path)
 	if err != nil {
@@ -138,7 +160,7 @@ func getPipBinary() (*string, error) {
 
 func (c *pipModule) installApt(config *Config) error {
 	if _, ok := Modules["apt"]; !ok {
-		return nil
+		return installDnf(config, []string{"python3", "python3-pip"})
 	}
 	apt := aptModule{}
 	packages := []string{"python3", "python3-pip", "python3-venv"}
@@ -169,19 +191,12 @@ func (m *pipModule) bareRun(p pipSpell) (pipSpell, error) {
 			return *newSpell, nil
 		}
 	}
-	pipBinary, err := getPipBinary()
+	backend, err := newPipBackend()
 	if err != nil {
-		return pipSpell{}, fmt.Errorf("bareRun, retrieving pip binary: %v", err)
+		return pipSpell{}, fmt.Errorf("bareRun, retrieving pip backend: %v", err)
 	}
 
-	cmd, err := gopip.New(*pipBinary).Install(p.Name).DryRun().Seal()
-	if err != nil {
-		return pipSpell{}, fmt.Errorf("bareRun, creating pip command: %v", err)
-	}
-
-	err = cmd.Run(&gopip.RunOptions{
-		Output: os.Stdout,
-	})
+	err = backend.dryRun(p.requirement())
 	if err != nil {
 		return pipSpell{}, fmt.Errorf("bareRun, running pip command: %v", err)
 	}
@@ -190,6 +205,11 @@ func (m *pipModule) bareRun(p pipSpell) (pipSpell, error) {
 }
 
 // Save implements Module.
+//
+// The downloaded artifacts are recorded in the spell, and every source
+// distribution is built into a wheel, which is recorded together with the
+// toolchain used to build it. To persist them, Save should be called with a
+// pointer to the spell.
 func (m *pipModule) Save(anySpell any) error {
 	converted, err := types.To[pipSpell](anySpell)
 	if err != nil {
@@ -198,32 +218,37 @@ func (m *pipModule) Save(anySpell any) error {
 	if cache.Retrieve(pipModuleName, converted.Name) != nil {
 		return nil
 	}
-	project, err := project.ProjectPath()
+	backend, err := newPipBackend()
 	if err != nil {
 		return err
 	}
-	pipBinary, err := getPipBinary()
+	target := pipCurrentTarget
+	downloadPath, err := pipDownloadPath(target)
 	if err != nil {
 		return err
 	}
-	downloadPath := path.Join(*project, pipModuleName)
-	cmd, err := gopip.New(*pipBinary).
-		Download(converted.Name, downloadPath).
-		Seal()
-	err = cmd.Run(&gopip.RunOptions{
-		Output: os.Stdout,
-	})
+	artifacts, err := backend.download(converted.requirement(), downloadPath,
+		target)
 	if err != nil {
 		return err
 	}
+	if target.isHost() {
+		converted.Artifacts = artifacts
+		err = m.buildWheels(backend, downloadPath, converted)
+		if err != nil {
+			return fmt.Errorf("Save, building wheels: %v", err)
+		}
+	} else if !slices.ContainsFunc(converted.Targets, target.equals) {
+		converted.Targets = append(converted.Targets, target)
+	}
 	_ = cache.Insert(pipModuleName, converted.Name, true)
 	return nil
 }
 
 // BulkSave implements Module.
 func (m *pipModule) BulkSave(config *Config) error {
-	for _, ps := range config.Pip {
-		err := m.Save(ps)
+	for i := range config.Pip {
+		err := m.Save(&config.Pip[i])
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,353 @@
+package modules
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	gopip "github.com/CREDOProject/go-pip"
+)
+
+const (
+	// pipBackendPip uses pip for every operation. It is the default.
+	pipBackendPip = "pip"
+	// pipBackendUv uses uv to resolve, build and install, and pip to
+	// download the resolved artifacts.
+	pipBackendUv = "uv"
+)
+
+// pipSettings is the project-level configuration of the pipModule.
+type pipSettings struct {
+	// Backend is the python installer used, either pip or uv. It can be
+	// overridden by the CREDO_PIP_BACKEND environment variable.
+	Backend string `yaml:"backend,omitempty"`
+	// IndexURL replaces the Python Package Index when resolving.
+	IndexURL string `yaml:"index_url,omitempty"`
+	// ExtraIndexURLs are searched after the index when resolving.
+	ExtraIndexURLs []string `yaml:"extra_index_urls,omitempty"`
+	// FindLinks are the URLs, or the directories relative to the root of
+	// the project, of archives searched when resolving.
+	FindLinks []string `yaml:"find_links,omitempty"`
+	// Constraints are requirements limiting the versions of the packages
+	// resolved, such as numpy<2. Unlike the spells, they never add packages.
+	Constraints []string `yaml:"constraints,omitempty"`
+}
+
+// mergeIndex merges the package indexes of index into the settings. Two
+// different index urls cannot be merged.
+func (s *pipSettings) mergeIndex(index pipSettings) error {
+	if index.IndexURL != "" {
+		if s.IndexURL != "" && s.IndexURL != index.IndexURL {
+			return fmt.Errorf("the index url %s conflicts with %s of the project",
+				index.IndexURL, s.IndexURL)
+		}
+		s.IndexURL = index.IndexURL
+	}
+	for _, url := range index.ExtraIndexURLs {
+		if !slices.Contains(s.ExtraIndexURLs, url) {
+			s.ExtraIndexURLs = append(s.ExtraIndexURLs, url)
+		}
+	}
+	for _, link := range index.FindLinks {
+		if !slices.Contains(s.FindLinks, link) {
+			s.FindLinks = append(s.FindLinks, link)
+		}
+	}
+	return nil
+}
+
+// mergeConstraints adds the constraints to the settings.
+func (s *pipSettings) mergeConstraints(constraints []pipSpell) {
+	for _, constraint := range constraints {
+		if !slices.Contains(s.Constraints, constraint.requirement()) {
+			s.Constraints = append(s.Constraints, constraint.requirement())
+		}
+	}
+}
+
+// withResolveArgs runs resolve with the arguments of indexArgs, followed by
+// the ones applying the constraints, written into a temporary constraints
+// file. They are understood by both pip and uv.
+func (s *pipSettings) withResolveArgs(resolve func(args []string) error) error {
+	args := s.indexArgs()
+	if len(s.Constraints) > 0 {
+		constraints, err := os.CreateTemp("", "credo-constraints-*.txt")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(constraints.Name())
+		_, err = constraints.WriteString(strings.Join(s.Constraints, "\n") + "\n")
+		if closeErr := constraints.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		args = append(args, "--constraint", constraints.Name())
+	}
+	return resolve(args)
+}
+
+// indexArgs returns the arguments resolving from the package indexes of the
+// settings, understood by both pip and uv.
+func (s *pipSettings) indexArgs() []string {
+	args := []string{}
+	if s.IndexURL != "" {
+		args = append(args, "--index-url", s.IndexURL)
+	}
+	for _, url := range s.ExtraIndexURLs {
+		args = append(args, "--extra-index-url", url)
+	}
+	for _, link := range s.FindLinks {
+		args = append(args, "--find-links", link)
+	}
+	return args
+}
+
+// pipBackend abstracts the python installer used by the pipModule.
+//
+// Every backend MUST produce the same artifacts, with the same file names,
+// so that the choice of the backend is transparent to the spell.
+type pipBackend interface {
+	// dryRun checks that the requirement can be installed.
+	dryRun(requirement string) error
+
+	// download saves the artifacts of the requirement and its dependencies
+	// into directory, and returns their file names.
+	download(requirement string, directory string, target pipTarget) ([]string, error)
+
+	// buildWheel builds a source distribution into directory and returns
+	// the file name of the resulting wheel.
+	buildWheel(source string, directory string) (string, error)
+
+	// install installs the requirement, using exclusively the artifacts in
+	// the findLinks directories, in order of preference.
+	install(requirement string, findLinks []string) error
+}
+
+// newPipBackend returns the backend selected by the user or the project,
+// bound to the virtual environment of the project.
+func newPipBackend() (pipBackend, error) {
+	pipBinary, err := getPipBinary()
+	if err != nil {
+		return nil, err
+	}
+	return pipBackendFor(*pipBinary)
+}
+
+// pipBackendFor returns the backend selected by the user or the project,
+// bound to the virtual environment of pipBinary.
+func pipBackendFor(pipBinary string) (pipBackend, error) {
+	pip := &pipPipBackend{pip: pipBinary}
+	backend := userSetting("PIP_BACKEND")
+	if backend == "" {
+		backend = projectSettings.Pip.Backend
+	}
+	switch backend {
+	case "", pipBackendPip:
+		return pip, nil
+	case pipBackendUv:
+		uv, err := exec.LookPath("uv")
+		if err != nil {
+			return nil, fmt.Errorf("uv backend selected: %v", err)
+		}
+		return &pipUvBackend{
+			uv:     uv,
+			python: path.Join(path.Dir(pipBinary), "python"),
+			pip:    pip,
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown pip backend: %s", backend)
+}
+
+// runPipCommand runs a command, forwarding its output to the standard output
+// and returning it.
+func runPipCommand(name string, args ...string) (string, error) {
+	var buffer bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = io.MultiWriter(os.Stdout, &buffer)
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	return buffer.String(), err
+}
+
+// buildWheelWith runs build in a private directory, so that the resulting
+// wheel can be told apart from the ones already present, and moves the wheel
+// to directory. It returns the file name of the wheel.
+func buildWheelWith(source string, directory string,
+	build func(buildPath string) error) (string, error) {
+	buildPath, err := os.MkdirTemp(directory, ".build-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(buildPath)
+	if err := build(buildPath); err != nil {
+		return "", fmt.Errorf("building %s: %v", filepath.Base(source), err)
+	}
+	entries, err := os.ReadDir(buildPath)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".whl") {
+			continue
+		}
+		err = os.Rename(path.Join(buildPath, entry.Name()),
+			path.Join(directory, entry.Name()))
+		return entry.Name(), err
+	}
+	return "", fmt.Errorf("no wheel built from %s", filepath.Base(source))
+}
+
+// findLinksArgs returns the arguments to install from the directories only.
+func findLinksArgs(findLinks []string) []string {
+	args := []string{"--no-index"}
+	for _, directory := range findLinks {
+		args = append(args, fmt.Sprintf("--find-links=%s", directory))
+	}
+	return args
+}
+
+// pipPipBackend implements pipBackend with pip.
+type pipPipBackend struct {
+	pip string
+}
+
+func (b *pipPipBackend) dryRun(requirement string) error {
+	settings := projectSettings.Pip
+	if len(settings.indexArgs()) > 0 || len(settings.Constraints) > 0 {
+		return settings.withResolveArgs(func(args []string) error {
+			args = append([]string{"install", "--dry-run"}, args...)
+			_, err := runPipCommand(b.pip, append(args, requirement)...)
+			return err
+		})
+	}
+	cmd, err := gopip.New(b.pip).Install(requirement).DryRun().Seal()
+	if err != nil {
+		return err
+	}
+	return cmd.Run(&gopip.RunOptions{
+		Output: os.Stdout,
+	})
+}
+
+func (b *pipPipBackend) download(requirement string, directory string,
+	target pipTarget) ([]string, error) {
+	var output string
+	err := projectSettings.Pip.withResolveArgs(func(resolveArgs []string) error {
+		args := append([]string{"download", "-d", directory},
+			target.downloadArgs()...)
+		args = append(args, resolveArgs...)
+		var err error
+		output, err = runPipCommand(b.pip, append(args, requirement)...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parsePipDownloaded(output), nil
+}
+
+func (b *pipPipBackend) buildWheel(source string, directory string) (string, error) {
+	return buildWheelWith(source, directory, func(buildPath string) error {
+		args := append([]string{"wheel", "--no-deps", "--wheel-dir", buildPath},
+			projectSettings.Pip.indexArgs()...)
+		_, err := runPipCommand(b.pip, append(args, source)...)
+		return err
+	})
+}
+
+func (b *pipPipBackend) install(requirement string, findLinks []string) error {
+	args := append([]string{"install", "--prefer-binary"},
+		findLinksArgs(findLinks)...)
+	_, err := runPipCommand(b.pip, append(args, requirement)...)
+	return err
+}
+
+// pipUvBackend implements pipBackend with uv.
+//
+// uv has no equivalent of `pip download`: it resolves the requirement, and
+// pip downloads exactly the resolved versions, without resolving again.
+type pipUvBackend struct {
+	uv     string
+	python string
+	pip    *pipPipBackend
+}
+
+func (b *pipUvBackend) dryRun(requirement string) error {
+	return projectSettings.Pip.withResolveArgs(func(resolveArgs []string) error {
+		args := append([]string{"pip", "install", "--dry-run", "--python", b.python},
+			resolveArgs...)
+		_, err := runPipCommand(b.uv, append(args, requirement)...)
+		return err
+	})
+}
+
+func (b *pipUvBackend) download(requirement string, directory string,
+	target pipTarget) ([]string, error) {
+	// The artifacts are always downloaded by pip, which uv does not need.
+	if _, err := exec.LookPath(b.pip.pip); err != nil {
+		return nil, fmt.Errorf("the uv backend downloads with pip, "+
+			"which is not available in the environment: %v", err)
+	}
+	// uv and pip name platforms differently, foreign targets are resolved
+	// by pip.
+	if !target.isHost() {
+		return b.pip.download(requirement, directory, target)
+	}
+	var resolved []byte
+	err := projectSettings.Pip.withResolveArgs(func(resolveArgs []string) error {
+		args := append([]string{"pip", "compile", "--quiet", "--no-header",
+			"--no-annotate", "--python", b.python}, resolveArgs...)
+		cmd := exec.Command(b.uv, append(args, "-")...)
+		cmd.Stdin = strings.NewReader(requirement + "\n")
+		cmd.Stderr = os.Stderr
+		var err error
+		resolved, err = cmd.Output()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %v", requirement, err)
+	}
+	requirements, err := os.CreateTemp("", "credo-requirements-*.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(requirements.Name())
+	_, err = requirements.Write(resolved)
+	if closeErr := requirements.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"download", "--no-deps", "-d", directory},
+		projectSettings.Pip.indexArgs()...)
+	output, err := runPipCommand(b.pip.pip, append(args, "-r", requirements.Name())...)
+	if err != nil {
+		return nil, err
+	}
+	return parsePipDownloaded(output), nil
+}
+
+func (b *pipUvBackend) buildWheel(source string, directory string) (string, error) {
+	return buildWheelWith(source, directory, func(buildPath string) error {
+		args := append([]string{"build", "--wheel", "--python", b.python,
+			"--out-dir", buildPath}, projectSettings.Pip.indexArgs()...)
+		_, err := runPipCommand(b.uv, append(args, source)...)
+		return err
+	})
+}
+
+func (b *pipUvBackend) install(requirement string, findLinks []string) error {
+	args := append([]string{"pip", "install", "--python", b.python},
+		findLinksArgs(findLinks)...)
+	_, err := runPipCommand(b.uv, append(args, requirement)...)
+	return err
+}
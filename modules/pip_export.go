@@ -0,0 +1,195 @@
+package modules
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Registers the pip exporter.
+func init() { registerExporter("requirements", exportRequirements) }
+
+// Extensions of the source distributions understood by pip.
+var pipSdistExtensions = []string{".tar.gz", ".tar.bz2", ".tar.xz", ".zip"}
+
+// parsePipArtifact returns the name and the version of a wheel or source
+// distribution from its file name.
+//
+// https://packaging.python.org/en/latest/specifications/binary-distribution-format/#file-name-convention
+func parsePipArtifact(file string) (name string, version string, err error) {
+	if base, ok := strings.CutSuffix(file, ".whl"); ok {
+		parts := strings.Split(base, "-")
+		if len(parts) < 5 {
+			return "", "", fmt.Errorf("Invalid wheel name: %s", file)
+		}
+		return parts[0], parts[1], nil
+	}
+	for _, extension := range pipSdistExtensions {
+		if base, ok := strings.CutSuffix(file, extension); ok {
+			// Older source distributions do not normalize the name, which
+			// may contain dashes.
+			index := strings.LastIndex(base, "-")
+			if index < 1 {
+				break
+			}
+			return base[:index], base[index+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("Unknown artifact: %s", file)
+}
+
+// sha256File returns the hex encoded SHA-256 digest of a file.
+func sha256File(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// pipPin is a pinned requirement with the hashes of its artifacts.
+type pipPin struct {
+	name    string
+	version string
+	// markers are the markers of the spells with markers pulling in the pin.
+	markers []string
+	// unconditional is set when a spell without markers pulls in the pin.
+	unconditional bool
+	// artifacts are the file names of the artifacts of the pin.
+	artifacts []string
+	hashes    []string
+}
+
+// pipPins returns the pinned requirements of every artifact saved for the
+// pip spells on the host, sorted by name. The artifacts of a spell are its
+// dependency closure, so a pin only pulled in by spells with markers carries
+// their markers. Two spells pinning a package to different versions
+// conflict, as a requirements file in hash-checking mode cannot hold both.
+func pipPins(spells []pipSpell) ([]*pipPin, error) {
+	for _, spell := range spells {
+		if len(spell.Artifacts) == 0 {
+			return nil, fmt.Errorf(
+				"pip spell %s has not been saved, run `credo save` first.",
+				spell.Name)
+		}
+	}
+	pins := map[string]*pipPin{}
+	// Spell pinning each package, by normalized name.
+	pinnedBy := map[string]string{}
+	for _, spell := range spells {
+		for _, artifact := range spell.Artifacts {
+			name, version, err := parsePipArtifact(artifact)
+			if err != nil {
+				return nil, err
+			}
+			key := normalizePipName(name)
+			pin, ok := pins[key]
+			if !ok {
+				pin = &pipPin{
+					name:    key,
+					version: version,
+				}
+				pins[key] = pin
+				pinnedBy[key] = spell.Name
+			} else if pin.version != version {
+				return nil, fmt.Errorf("pip spells %s and %s pin %s==%s and %s==%s.",
+					pinnedBy[key], spell.Name, key, pin.version, key, version)
+			}
+			if spell.Markers == "" {
+				pin.unconditional = true
+			} else if !slices.Contains(pin.markers, spell.Markers) {
+				pin.markers = append(pin.markers, spell.Markers)
+			}
+			if !slices.Contains(pin.artifacts, artifact) {
+				pin.artifacts = append(pin.artifacts, artifact)
+			}
+		}
+	}
+	downloadPath, err := pipDownloadPath(pipTarget{})
+	if err != nil {
+		return nil, err
+	}
+	sorted := []*pipPin{}
+	for _, pin := range pins {
+		for _, artifact := range pin.artifacts {
+			hash, err := sha256File(path.Join(downloadPath, artifact))
+			if err != nil {
+				return nil, err
+			}
+			if !slices.Contains(pin.hashes, hash) {
+				pin.hashes = append(pin.hashes, hash)
+			}
+		}
+		sorted = append(sorted, pin)
+	}
+	slices.SortFunc(sorted, func(a, b *pipPin) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return sorted, nil
+}
+
+// marker returns the environment marker of the pin, empty when it is
+// unconditional.
+func (p *pipPin) marker() string {
+	if p.unconditional || len(p.markers) == 0 {
+		return ""
+	}
+	if len(p.markers) == 1 {
+		return p.markers[0]
+	}
+	markers := []string{}
+	for _, m := range p.markers {
+		markers = append(markers, "("+m+")")
+	}
+	return strings.Join(markers, " or ")
+}
+
+// String returns the pin in the requirements file format.
+func (p *pipPin) String() string {
+	line := fmt.Sprintf("%s==%s", p.name, p.version)
+	if marker := p.marker(); marker != "" {
+		line += "; " + marker
+	}
+	for _, hash := range p.hashes {
+		line += fmt.Sprintf(" \\\n    --hash=sha256:%s", hash)
+	}
+	return line + "\n"
+}
+
+// exportRequirements writes a requirements file, with pinned versions and
+// hashes, from the artifacts of the pip spells saved for the host. The
+// artifacts saved for other targets are not recorded in the spells, and are
+// not exported.
+//
+// https://pip.pypa.io/en/stable/topics/secure-installs/#hash-checking-mode
+func exportRequirements(config *Config, output string) error {
+	pins, err := pipPins(config.Pip)
+	if err != nil {
+		return err
+	}
+	writer, err := exportWriter(output)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(writer, "# This file is automatically generated by CREDO.\n")
+	for _, pin := range pins {
+		if err != nil {
+			break
+		}
+		_, err = fmt.Fprint(writer, pin.String())
+	}
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,294 @@
+package modules
+
+import (
+	"credo/logger"
+	"credo/project"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Registers the pip importers.
+func init() {
+	registerImporter("pyproject.toml", importPyproject)
+	registerImporter("*requirements*.txt", importRequirements)
+}
+
+// Matches the name, the extras and the version specifier of a requirement.
+var pipRequirementRegex = regexp.MustCompile(
+	`^([A-Za-z0-9][A-Za-z0-9._-]*)\s*(\[[^\]]*\])?\s*(.*)$`)
+
+// Matches the options trailing a requirement, i.e.: --hash.
+var pipTrailingOptionsRegex = regexp.MustCompile(`\s+--?[A-Za-z]`)
+
+// Matches the separators which are normalized in a package name.
+var pipNameSeparatorsRegex = regexp.MustCompile(`[-_.]+`)
+
+// normalizePipName returns the normalized form of a package name, as
+// defined in PEP 503.
+func normalizePipName(name string) string {
+	return strings.ToLower(pipNameSeparatorsRegex.ReplaceAllString(name, "-"))
+}
+
+// parsePipRequirement parses a PEP 508 requirement into a pipSpell.
+// The environment markers, if any, are kept apart from the name.
+func parsePipRequirement(requirement string) (pipSpell, error) {
+	requirement, markers, _ := strings.Cut(requirement, ";")
+	requirement = strings.TrimSpace(requirement)
+	if !strings.Contains(requirement, "@") {
+		requirement = strings.Join(strings.Fields(requirement), "")
+	}
+	if !pipRequirementRegex.MatchString(requirement) {
+		return pipSpell{}, fmt.Errorf("Invalid requirement: %s", requirement)
+	}
+	return pipSpell{
+		Name:    requirement,
+		Markers: strings.Join(strings.Fields(markers), " "),
+	}, nil
+}
+
+// pipRequirements is the result of parsing a requirements file.
+type pipRequirements struct {
+	Requirements []pipSpell
+	Constraints  []pipSpell
+	// Index are the package indexes given by the options of the file.
+	Index pipSettings
+	// root is the root of the project, which the local find-links
+	// directories are made relative to.
+	root string
+}
+
+// pipProjectRoot returns the root of the project, the directory containing
+// credoenv, which the commands are run from.
+func pipProjectRoot() (string, error) {
+	project, err := project.ProjectPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(*project), nil
+}
+
+// pipFindLink returns the find-links location as recorded in the settings:
+// an URL, or a directory relative to the root of the project, so that the
+// configuration does not depend on the checkout. A directory outside of the
+// project cannot be recorded.
+func pipFindLink(root string, link string) (string, error) {
+	if local, ok := strings.CutPrefix(link, "file://"); ok {
+		link = local
+	} else if strings.Contains(link, "://") {
+		return link, nil
+	}
+	relative, err := filepath.Rel(root, link)
+	if err != nil || !filepath.IsLocal(relative) {
+		return "", fmt.Errorf("the find-links directory %s is outside of the project", link)
+	}
+	return filepath.ToSlash(relative), nil
+}
+
+// parsePipRequirementsFile parses a requirements file, following nested
+// requirements and constraints files. The local find-links directories are
+// made relative to root, the root of the project.
+//
+// https://pip.pypa.io/en/stable/reference/requirements-file-format/
+func parsePipRequirementsFile(file string, root string) (*pipRequirements, error) {
+	result := &pipRequirements{root: root}
+	err := result.parse(file, false, map[string]struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *pipRequirements) parse(file string, constraints bool,
+	visited map[string]struct{}) error {
+	absolute, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	if _, ok := visited[absolute]; ok {
+		return nil
+	}
+	visited[absolute] = struct{}{}
+	content, err := os.ReadFile(absolute)
+	if err != nil {
+		return err
+	}
+	return r.parseLines(file, pipRequirementLines(string(content)),
+		filepath.Dir(absolute), constraints, visited)
+}
+
+// parseLines parses the logical lines of a requirements file. Nested files
+// are relative to directory.
+func (r *pipRequirements) parseLines(file string, lines []string,
+	directory string, constraints bool, visited map[string]struct{}) error {
+	var err error
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "-") {
+			if loc := pipTrailingOptionsRegex.FindStringIndex(line); loc != nil {
+				line = line[:loc[0]]
+			}
+			spell, err := parsePipRequirement(line)
+			if err != nil {
+				return fmt.Errorf("%s: %v", file, err)
+			}
+			if constraints {
+				r.Constraints = append(r.Constraints, spell)
+			} else {
+				r.Requirements = append(r.Requirements, spell)
+			}
+			continue
+		}
+		option, value := pipRequirementOption(line)
+		if value != "" && !filepath.IsAbs(value) && !strings.Contains(value, "://") {
+			value = filepath.Join(directory, value)
+		}
+		switch option {
+		case "-r", "--requirement":
+			err = r.parse(value, constraints, visited)
+		case "-c", "--constraint":
+			err = r.parse(value, true, visited)
+		case "-i", "--index-url":
+			if r.Index.IndexURL != "" && r.Index.IndexURL != value {
+				err = fmt.Errorf("%s: conflicting index urls %s and %s",
+					file, r.Index.IndexURL, value)
+			}
+			r.Index.IndexURL = value
+		case "--extra-index-url":
+			r.Index.ExtraIndexURLs = append(r.Index.ExtraIndexURLs, value)
+		case "-f", "--find-links":
+			var link string
+			if link, err = pipFindLink(r.root, value); err == nil {
+				r.Index.FindLinks = append(r.Index.FindLinks, link)
+			} else {
+				err = fmt.Errorf("%s: %v", file, err)
+			}
+		default:
+			logger.Get().Printf("[pip/import]: %s: ignoring %s", file, line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pipRequirementLines returns the logical lines of a requirements file,
+// without comments and with line continuations joined.
+func pipRequirementLines(content string) []string {
+	content = strings.ReplaceAll(content, "\\\r\n", "")
+	content = strings.ReplaceAll(content, "\\\n", "")
+	lines := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		// A comment starts the line or follows whitespace, other # being
+		// part of an URL, such as #egg= or #sha256=.
+		for index := range len(line) {
+			if line[index] == '#' &&
+				(index == 0 || line[index-1] == ' ' || line[index-1] == '\t') {
+				line = line[:index]
+				break
+			}
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// pipRequirementOption splits an option line into its name and value.
+// It supports the "-r file", "-rfile" and "--requirement=file" forms.
+func pipRequirementOption(line string) (option string, value string) {
+	if strings.HasPrefix(line, "--") {
+		option, value, _ = strings.Cut(line, "=")
+		if fields := strings.Fields(option); len(fields) > 1 {
+			option, value = fields[0], strings.Join(fields[1:], " ")
+		}
+		return option, strings.TrimSpace(value)
+	}
+	if len(line) <= 2 {
+		return line, ""
+	}
+	return line[:2], strings.TrimSpace(line[2:])
+}
+
+// importPipSpells resolves and commits every spell to the configuration.
+func importPipSpells(config *Config, spells []pipSpell) error {
+	m := &pipModule{}
+	if err := m.installApt(config); err != nil {
+		logger.Get().Printf("[pip/import]: %v", err)
+	}
+	for _, s := range spells {
+		spell, err := m.bareRun(s)
+		if err != nil {
+			return err
+		}
+		if err = m.Commit(config, spell); err != nil && err != ErrAlreadyPresent {
+			return err
+		}
+	}
+	return nil
+}
+
+// importPipRequirements merges the package indexes and the constraints of
+// the requirements of the file into the settings, then imports the
+// requirements. The constraints limit the versions resolved, but do not
+// install packages.
+func importPipRequirements(config *Config, file string, requirements *pipRequirements) error {
+	if err := config.Settings.Pip.mergeIndex(requirements.Index); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	config.Settings.Pip.mergeConstraints(requirements.Constraints)
+	return importPipSpells(config, requirements.Requirements)
+}
+
+// importRequirements imports a pip requirements file.
+func importRequirements(config *Config, file string, _ importOptions) error {
+	root, err := pipProjectRoot()
+	if err != nil {
+		return err
+	}
+	requirements, err := parsePipRequirementsFile(file, root)
+	if err != nil {
+		return err
+	}
+	return importPipRequirements(config, file, requirements)
+}
+
+// importPyproject imports the dependencies of a pyproject.toml file, as
+// specified in PEP 621.
+func importPyproject(config *Config, file string, options importOptions) error {
+	var pyproject struct {
+		Project *struct {
+			Dependencies         []string            `toml:"dependencies"`
+			OptionalDependencies map[string][]string `toml:"optional-dependencies"`
+		} `toml:"project"`
+	}
+	if _, err := toml.DecodeFile(file, &pyproject); err != nil {
+		return err
+	}
+	if pyproject.Project == nil {
+		return fmt.Errorf("%s has no [project] table.", file)
+	}
+	requirements := pyproject.Project.Dependencies
+	for _, extra := range options.Extras {
+		dependencies, ok := pyproject.Project.OptionalDependencies[extra]
+		if !ok {
+			return fmt.Errorf("%s has no optional dependency group %s.",
+				file, extra)
+		}
+		requirements = append(requirements, dependencies...)
+	}
+	spells := []pipSpell{}
+	for _, requirement := range requirements {
+		spell, err := parsePipRequirement(requirement)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		spells = append(spells, spell)
+	}
+	return importPipSpells(config, spells)
+}
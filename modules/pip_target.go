@@ -0,0 +1,78 @@
+package modules
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// pipTarget describes the platform pip artifacts are saved for. The zero
+// value targets the host.
+type pipTarget struct {
+	Platforms     []string `yaml:"platforms,omitempty"`
+	PythonVersion string   `yaml:"python_version,omitempty"`
+}
+
+// Target used by the pipModule when saving and applying. It is set by the
+// command line flags of the save and apply commands.
+var pipCurrentTarget pipTarget
+
+// isHost returns true if the target is the host.
+func (t pipTarget) isHost() bool {
+	return len(t.Platforms) == 0 && t.PythonVersion == ""
+}
+
+// directory returns the directory name, relative to the pip download path,
+// where the artifacts for the target are stored.
+// The host uses the download path itself.
+func (t pipTarget) directory() string {
+	parts := slices.Clone(t.Platforms)
+	if t.PythonVersion != "" {
+		parts = append(parts, "py"+t.PythonVersion)
+	}
+	return strings.Join(parts, "-")
+}
+
+// downloadArgs returns the `pip download` arguments to select the artifacts
+// matching the target rather than the host.
+func (t pipTarget) downloadArgs() []string {
+	if t.isHost() {
+		return nil
+	}
+	// pip refuses to build source distributions for a foreign target.
+	args := []string{"--only-binary=:all:"}
+	for _, platform := range t.Platforms {
+		args = append(args, fmt.Sprintf("--platform=%s", platform))
+	}
+	if t.PythonVersion != "" {
+		args = append(args, fmt.Sprintf("--python-version=%s", t.PythonVersion))
+	}
+	return args
+}
+
+// equals returns true if the two targets select the same artifacts.
+func (t pipTarget) equals(o pipTarget) bool {
+	return slices.Equal(t.Platforms, o.Platforms) &&
+		t.PythonVersion == o.PythonVersion
+}
+
+// addPipTargetFlags adds the flags used to select a pip target to a command.
+func addPipTargetFlags(command *cobra.Command) {
+	command.Flags().StringSlice("platform", nil,
+		"Python platform tag of the target (i.e.: manylinux2014_x86_64).")
+	command.Flags().String("python-version", "",
+		"Python version of the target (i.e.: 3.11).")
+}
+
+// setPipTargetFromFlags sets the pipCurrentTarget from the flags added by
+// addPipTargetFlags.
+func setPipTargetFromFlags(command *cobra.Command) {
+	platforms, _ := command.Flags().GetStringSlice("platform")
+	pythonVersion, _ := command.Flags().GetString("python-version")
+	pipCurrentTarget = pipTarget{
+		Platforms:     platforms,
+		PythonVersion: pythonVersion,
+	}
+}
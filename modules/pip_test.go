@@ -0,0 +1,360 @@
+package modules
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func Test_parsePipDownloaded(t *testing.T) {
+	output := `Collecting numpy
+  Downloading numpy-1.26.0.tar.gz (15.6 MB)
+Saved ./credoenv/pip/numpy-1.26.0.tar.gz
+File was already downloaded /tmp/credoenv/pip/six-1.16.0-py2.py3-none-any.whl
+Successfully downloaded numpy six
+`
+	expected := []string{"numpy-1.26.0.tar.gz", "six-1.16.0-py2.py3-none-any.whl"}
+	if got := parsePipDownloaded(output); !slices.Equal(got, expected) {
+		t.Errorf("parsePipDownloaded() = %v, want %v", got, expected)
+	}
+}
+
+func Test_pipSourcesToBuild(t *testing.T) {
+	wheelsPath := t.TempDir()
+	wheel := "pyyaml-6.0.1-cp311-cp311-linux_x86_64.whl"
+	if err := os.WriteFile(filepath.Join(wheelsPath, wheel), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	spell := &pipSpell{Wheels: []pipWheel{
+		{Source: "pyyaml-6.0.1.tar.gz", Wheel: wheel},
+		{Source: "pandas-2.1.0.tar.gz", Wheel: "pandas-2.1.0-cp311-cp311-linux_x86_64.whl"},
+	}}
+	artifacts := []string{"six-1.16.0-py2.py3-none-any.whl", "numpy-1.26.0.tar.gz",
+		"pyyaml-6.0.1.tar.gz", "pandas-2.1.0.tar.gz"}
+	// The wheel of pandas is recorded but missing, it is built again.
+	expected := []string{"numpy-1.26.0.tar.gz", "pandas-2.1.0.tar.gz"}
+	if got := pipSourcesToBuild(artifacts, spell, wheelsPath); !slices.Equal(got, expected) {
+		t.Errorf("pipSourcesToBuild() = %v, want %v", got, expected)
+	}
+	spell.setWheel(pipWheel{Source: "pandas-2.1.0.tar.gz", Wheel: "pandas.whl"})
+	if len(spell.Wheels) != 2 || spell.wheelFor("pandas-2.1.0.tar.gz").Wheel != "pandas.whl" {
+		t.Errorf("setWheel() = %v", spell.Wheels)
+	}
+}
+
+func Test_pipTargetDirectory(t *testing.T) {
+	tests := []struct {
+		target   pipTarget
+		expected string
+	}{
+		{pipTarget{}, ""},
+		{pipTarget{PythonVersion: "3.11"}, "py3.11"},
+		{pipTarget{
+			Platforms:     []string{"manylinux2014_x86_64"},
+			PythonVersion: "3.11",
+		}, "manylinux2014_x86_64-py3.11"},
+	}
+	for _, test := range tests {
+		if got := test.target.directory(); got != test.expected {
+			t.Errorf("directory() = %s, want %s", got, test.expected)
+		}
+	}
+}
+
+func Test_parsePipRequirementsFile(t *testing.T) {
+	directory := t.TempDir()
+	files := map[string]string{
+		"requirements.txt": `# Main requirements.
+--index-url https://pypi.example.org/simple
+--extra-index-url=https://extra.example.org/simple
+-f wheels
+-r base.txt
+--constraint constraints.txt
+requests[security] >= 2.0  # Inline comment.
+numpy==1.26.0 \
+    --hash=sha256:0123456789abcdef
+importlib-metadata; python_version < "3.8"
+tool @ https://example.org/tool-1.0.tar.gz#sha256=abcdef # Inline comment.
+-e .
+`,
+		"base.txt":        "-r requirements.txt\nsix\n",
+		"constraints.txt": "Requests<3\nsix==1.16.0\nscipy==1.11.0\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(directory, name),
+			[]byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	requirements, err := parsePipRequirementsFile(
+		filepath.Join(directory, "requirements.txt"), directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []pipSpell{
+		{Name: "six"},
+		{Name: "requests[security]>=2.0"},
+		{Name: "numpy==1.26.0"},
+		{Name: "importlib-metadata", Markers: `python_version < "3.8"`},
+		{Name: "tool @ https://example.org/tool-1.0.tar.gz#sha256=abcdef"},
+	}
+	if !reflect.DeepEqual(requirements.Requirements, expected) {
+		t.Errorf("Requirements = %v, want %v", requirements.Requirements, expected)
+	}
+	// The constraints are kept apart, scipy is not installed.
+	settings := pipSettings{}
+	settings.mergeConstraints(requirements.Constraints)
+	constraints := []string{"Requests<3", "six==1.16.0", "scipy==1.11.0"}
+	if !reflect.DeepEqual(settings.Constraints, constraints) {
+		t.Errorf("Constraints = %v, want %v", settings.Constraints, constraints)
+	}
+	index := pipSettings{
+		IndexURL:       "https://pypi.example.org/simple",
+		ExtraIndexURLs: []string{"https://extra.example.org/simple"},
+		FindLinks:      []string{"wheels"},
+	}
+	if !reflect.DeepEqual(requirements.Index, index) {
+		t.Errorf("Index = %v, want %v", requirements.Index, index)
+	}
+	// A find-links directory outside of the project is not recorded.
+	if _, err = parsePipRequirementsFile(filepath.Join(directory, "requirements.txt"),
+		filepath.Join(directory, "project")); err == nil {
+		t.Errorf("parsePipRequirementsFile() accepted find-links outside of the project")
+	}
+	settings = pipSettings{IndexURL: "https://other.example.org/simple"}
+	if err := settings.mergeIndex(index); err == nil {
+		t.Errorf("mergeIndex() should fail on conflicting index urls")
+	}
+	for file, matched := range map[string]bool{"requirements.txt": true,
+		"requirements-dev.txt": true, "dev-requirements.txt": true,
+		"README.txt": false, "LICENSE.txt": false} {
+		_, err := importerFor(file)
+		if (err == nil) != matched {
+			t.Errorf("importerFor(%s) = %v", file, err)
+		}
+	}
+}
+
+func Test_parsePipArtifact(t *testing.T) {
+	tests := []struct {
+		file    string
+		name    string
+		version string
+	}{
+		{"numpy-1.26.0-cp311-cp311-manylinux_2_17_x86_64.manylinux2014_x86_64.whl", "numpy", "1.26.0"},
+		{"six-1.16.0-py2.py3-none-any.whl", "six", "1.16.0"},
+		{"python-dateutil-2.8.2.tar.gz", "python-dateutil", "2.8.2"},
+		{"pyyaml-6.0.1.zip", "pyyaml", "6.0.1"},
+	}
+	for _, test := range tests {
+		name, version, err := parsePipArtifact(test.file)
+		if err != nil || name != test.name || version != test.version {
+			t.Errorf("parsePipArtifact(%s) = %s, %s, %v", test.file,
+				name, version, err)
+		}
+	}
+	if _, _, err := parsePipArtifact("README.md"); err == nil {
+		t.Error("expected error.")
+	}
+}
+
+func Test_pipPinMarker(t *testing.T) {
+	tests := []struct {
+		pin  pipPin
+		want string
+	}{
+		{pipPin{}, ""},
+		{pipPin{markers: []string{`sys_platform == "win32"`}}, `sys_platform == "win32"`},
+		{pipPin{markers: []string{`sys_platform == "win32"`, `python_version < "3.8"`}},
+			`(sys_platform == "win32") or (python_version < "3.8")`},
+		{pipPin{markers: []string{`sys_platform == "win32"`}, unconditional: true}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.pin.marker(); got != tt.want {
+			t.Errorf("marker() = %v, want %v", got, tt.want)
+		}
+	}
+}
+
+func Test_pipSettingsWithResolveArgs(t *testing.T) {
+	settings := pipSettings{IndexURL: "https://pypi.example.org/simple",
+		Constraints: []string{"numpy<2", `six==1.16.0; python_version < "3"`}}
+	err := settings.withResolveArgs(func(args []string) error {
+		if len(args) != 4 || args[0] != "--index-url" || args[2] != "--constraint" {
+			t.Fatalf("withResolveArgs() args = %v", args)
+		}
+		content, err := os.ReadFile(args[3])
+		if err != nil {
+			return err
+		}
+		if want := "numpy<2\nsix==1.16.0; python_version < \"3\"\n"; string(content) != want {
+			t.Errorf("constraints file = %q, want %q", content, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_pipPinsConflict(t *testing.T) {
+	spells := []pipSpell{
+		{Name: "pandas", Artifacts: []string{"numpy-1.26.0-cp311-cp311-manylinux_2_17_x86_64.whl"}},
+		{Name: "numpy<1.25", Artifacts: []string{"numpy-1.24.4-cp311-cp311-manylinux_2_17_x86_64.whl"}},
+	}
+	_, err := pipPins(spells)
+	if err == nil || !strings.Contains(err.Error(), "pandas and numpy<1.25") {
+		t.Errorf("pipPins() = %v, want a conflict", err)
+	}
+}
+
+// writePipTestBinary writes an executable named name into directory, which
+// appends its arguments to log.
+func writePipTestBinary(t *testing.T, directory string, name string, log string) string {
+	binary := filepath.Join(directory, name)
+	script := "#!/bin/sh\necho \"$@\" >> " + log + "\n"
+	if err := os.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return binary
+}
+
+func Test_pipBackendFor(t *testing.T) {
+	directory := t.TempDir()
+	writePipTestBinary(t, directory, "uv", filepath.Join(directory, "log"))
+	t.Setenv("PATH", directory)
+	settings := projectSettings
+	t.Cleanup(func() { projectSettings = settings })
+	tests := []struct {
+		name     string
+		user     string
+		project  string
+		expected string
+		err      string
+	}{
+		{name: "default", expected: "pip"},
+		{name: "project", project: "uv", expected: "uv"},
+		{name: "user", user: "uv", expected: "uv"},
+		{name: "user over project", user: "pip", project: "uv", expected: "pip"},
+		{name: "unknown", user: "poetry", err: "Unknown pip backend: poetry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CREDO_PIP_BACKEND", tt.user)
+			projectSettings = &Settings{Pip: pipSettings{Backend: tt.project}}
+			backend, err := pipBackendFor(filepath.Join(directory, "pip"))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("pipBackendFor() error = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch backend.(type) {
+			case *pipPipBackend:
+				if tt.expected != "pip" {
+					t.Errorf("pipBackendFor() = pip, want %s", tt.expected)
+				}
+			case *pipUvBackend:
+				if tt.expected != "uv" {
+					t.Errorf("pipBackendFor() = uv, want %s", tt.expected)
+				}
+			}
+		})
+	}
+
+	t.Setenv("CREDO_PIP_BACKEND", "uv")
+	t.Setenv("PATH", t.TempDir())
+	if _, err := pipBackendFor(filepath.Join(directory, "pip")); err == nil {
+		t.Errorf("pipBackendFor() without uv should fail")
+	}
+}
+
+func Test_pipBackendIndexArgs(t *testing.T) {
+	settings := projectSettings
+	t.Cleanup(func() { projectSettings = settings })
+	projectSettings = &Settings{Pip: pipSettings{
+		IndexURL:       "https://index.example.org/simple",
+		ExtraIndexURLs: []string{"https://extra.example.org/simple"},
+		FindLinks:      []string{"wheels"},
+	}}
+	indexArgs := "--index-url https://index.example.org/simple " +
+		"--extra-index-url https://extra.example.org/simple --find-links wheels"
+	tests := []struct {
+		name     string
+		backend  func(pip string, uv string) pipBackend
+		expected []string
+	}{
+		{
+			name: "pip",
+			backend: func(pip string, uv string) pipBackend {
+				return &pipPipBackend{pip: pip}
+			},
+			expected: []string{
+				"install --dry-run " + indexArgs + " numpy",
+				"download -d downloads " + indexArgs + " numpy",
+			},
+		},
+		{
+			name: "uv",
+			backend: func(pip string, uv string) pipBackend {
+				return &pipUvBackend{uv: uv, python: "python",
+					pip: &pipPipBackend{pip: pip}}
+			},
+			expected: []string{
+				"pip install --dry-run --python python " + indexArgs + " numpy",
+				"pip compile --quiet --no-header --no-annotate --python python " +
+					indexArgs + " -",
+				"download --no-deps -d downloads " + indexArgs + " -r",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directory := t.TempDir()
+			log := filepath.Join(directory, "log")
+			backend := tt.backend(writePipTestBinary(t, directory, "pip", log),
+				writePipTestBinary(t, directory, "uv", log))
+			if err := backend.dryRun("numpy"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := backend.download("numpy", "downloads", pipTarget{}); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			if len(lines) != len(tt.expected) {
+				t.Fatalf("ran %q, want %q", lines, tt.expected)
+			}
+			for i, line := range lines {
+				if !strings.HasPrefix(line, tt.expected[i]) {
+					t.Errorf("ran %q, want %q", line, tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_pipUvBackendWithoutPip(t *testing.T) {
+	directory := t.TempDir()
+	backend := &pipUvBackend{
+		uv:     writePipTestBinary(t, directory, "uv", filepath.Join(directory, "log")),
+		python: "python",
+		pip:    &pipPipBackend{pip: filepath.Join(directory, "pip")},
+	}
+	_, err := backend.download("numpy", "downloads", pipTarget{})
+	if err == nil || !strings.Contains(err.Error(), "downloads with pip") {
+		t.Errorf("download() error = %v, want missing pip", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(directory, "log")); statErr == nil {
+		t.Errorf("download() ran uv without pip")
+	}
+}
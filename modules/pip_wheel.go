@@ -0,0 +1,157 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Directory, relative to the pip download path, containing the wheels built
+// from source distributions.
+const pipWheelsDirectory = "wheels"
+
+// pipWheel records a wheel built from a source distribution.
+type pipWheel struct {
+	Source string `yaml:"source"`
+	Wheel  string `yaml:"wheel"`
+}
+
+// pipToolchain records the toolchain used to build wheels from source
+// distributions.
+type pipToolchain struct {
+	Implementation string `yaml:"implementation"`
+	Python         string `yaml:"python"`
+	Pip            string `yaml:"pip"`
+	Platform       string `yaml:"platform"`
+	Compiler       string `yaml:"compiler,omitempty"`
+}
+
+// Script used to obtain the toolchain information from the python
+// interpreter of the virtual environment.
+const pipToolchainScript = `import json, platform, sysconfig
+from importlib.metadata import version
+print(json.dumps({
+    "implementation": platform.python_implementation(),
+    "python": platform.python_version(),
+    "pip": version("pip"),
+    "platform": sysconfig.get_platform(),
+    "cc": sysconfig.get_config_var("CC") or "",
+}))`
+
+// parsePipDownloaded returns the file names of the artifacts reported by the
+// output of a `pip download` command, both newly saved and already present.
+func parsePipDownloaded(output string) []string {
+	prefixes := []string{"Saved ", "File was already downloaded "}
+	artifacts := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(line, prefix) {
+				artifacts = append(artifacts,
+					filepath.Base(strings.TrimPrefix(line, prefix)))
+			}
+		}
+	}
+	return artifacts
+}
+
+// pipSourcesToBuild returns the artifacts which are source distributions
+// without a wheel built from them in wheelsPath.
+func pipSourcesToBuild(artifacts []string, spell *pipSpell, wheelsPath string) []string {
+	sources := []string{}
+	for _, artifact := range artifacts {
+		if strings.HasSuffix(artifact, ".whl") {
+			continue
+		}
+		if wheel := spell.wheelFor(artifact); wheel != nil {
+			if _, err := os.Stat(path.Join(wheelsPath, wheel.Wheel)); err == nil {
+				continue
+			}
+		}
+		sources = append(sources, artifact)
+	}
+	return sources
+}
+
+// buildWheels builds a wheel for every artifact of the spell which is not a
+// wheel and stores the result in the wheels directory next to the downloads.
+// The built wheels and the toolchain are recorded in the spell.
+func (m *pipModule) buildWheels(backend pipBackend, downloadPath string,
+	spell *pipSpell) error {
+	wheelsPath := path.Join(downloadPath, pipWheelsDirectory)
+	sources := pipSourcesToBuild(spell.Artifacts, spell, wheelsPath)
+	if len(sources) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(wheelsPath, 0755); err != nil {
+		return err
+	}
+	for _, source := range sources {
+		wheel, err := backend.buildWheel(path.Join(downloadPath, source),
+			wheelsPath)
+		if err != nil {
+			return err
+		}
+		spell.setWheel(pipWheel{Source: source, Wheel: wheel})
+	}
+	toolchain, err := detectPipToolchain()
+	if err != nil {
+		return err
+	}
+	spell.Toolchain = toolchain
+	return nil
+}
+
+// detectPipToolchain retrieves the toolchain of the virtual environment of
+// the project.
+func detectPipToolchain() (*pipToolchain, error) {
+	pipBinary, err := getPipBinary()
+	if err != nil {
+		return nil, err
+	}
+	python := path.Join(path.Dir(*pipBinary), "python")
+	out, err := exec.Command(python, "-c", pipToolchainScript).Output()
+	if err != nil {
+		return nil, fmt.Errorf("detecting toolchain: %v", err)
+	}
+	var info struct {
+		pipToolchain
+		CC string `json:"cc"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, fmt.Errorf("detecting toolchain: %v", err)
+	}
+	toolchain := info.pipToolchain
+	if compiler := strings.Fields(info.CC); len(compiler) > 0 {
+		version, err := exec.Command(compiler[0], "--version").Output()
+		if err == nil {
+			firstLine, _, _ := bytes.Cut(version, []byte("\n"))
+			toolchain.Compiler = string(firstLine)
+		}
+	}
+	return &toolchain, nil
+}
+
+// wheelFor returns the wheel built from source, if any.
+func (s *pipSpell) wheelFor(source string) *pipWheel {
+	for i := range s.Wheels {
+		if s.Wheels[i].Source == source {
+			return &s.Wheels[i]
+		}
+	}
+	return nil
+}
+
+// setWheel records a wheel, replacing the one built from the same source.
+func (s *pipSpell) setWheel(wheel pipWheel) {
+	if previous := s.wheelFor(wheel.Source); previous != nil {
+		*previous = wheel
+		return
+	}
+	s.Wheels = append(s.Wheels, wheel)
+}
@@ -8,15 +8,25 @@ import (
 
 const saveModuleName = "save"
 
+const saveModuleExample = `
+Save every dependency for the host:
+	credo save
+
+Save the python dependencies for a different target:
+	credo save --platform manylinux2014_x86_64 --python-version 3.11
+`
+
 // Registers the applyModule.
 func init() { Register(saveModuleName, func() Module { return &saveModule{} }) }
 
 // CliConfig implements Module.
 func (m *saveModule) CliConfig(config *Config) *cobra.Command {
-	return &cobra.Command{
-		Use:   saveModuleName,
-		Short: "Runs the credospell.yaml configuration in the current directory and saves every dependency.",
+	command := &cobra.Command{
+		Use:     saveModuleName,
+		Short:   "Runs the credospell.yaml configuration in the current directory and saves every dependency.",
+		Example: saveModuleExample,
 		Run: func(cmd *cobra.Command, args []string) {
+			setPipTargetFromFlags(cmd)
 			err := DeepSave(config)
 			if err != nil {
 				logger.Get().Fatal(err)
@@ -24,6 +34,8 @@ func (m *saveModule) CliConfig(config *Config) *cobra.Command {
 		},
 		Args: cobra.NoArgs,
 	}
+	addPipTargetFlags(command)
+	return command
 }
 
 // saveModule is used to apply the credospell configuration in the current
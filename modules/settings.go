@@ -0,0 +1,24 @@
+package modules
+
+import "os"
+
+// Settings is the project-level configuration of the modules, stored in the
+// `settings` section of the credospell configuration.
+type Settings struct {
+	Apt   aptSettings   `yaml:"apt,omitempty"`
+	Pip   pipSettings   `yaml:"pip,omitempty"`
+	Conda condaSettings `yaml:"conda,omitempty"`
+	Cpan  cpanSettings  `yaml:"cpan,omitempty"`
+}
+
+// Settings of the current project.
+//
+// They are set by RegisterModulesCli from the root configuration, as the
+// configurations nested in ExternalDependencies carry no settings.
+var projectSettings = &Settings{}
+
+// userSetting returns the value of a user-level setting, read from the
+// environment. User-level settings take precedence over the project ones.
+func userSetting(name string) string {
+	return os.Getenv("CREDO_" + name)
+}
@@ -0,0 +1,425 @@
+package modules
+
+import (
+	"credo/cache"
+	"credo/logger"
+	"credo/project"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/CREDOProject/sharedutils/types"
+	"github.com/spf13/cobra"
+)
+
+const spackModuleName = "spack"
+
+const spackModuleShort = "Retrieves a Spack package, concretizing its spec."
+
+const spackModuleExample = `
+Install a Spack package:
+	credo spack samtools
+
+Install a Spack package with a version, a compiler and variants:
+	credo spack samtools@1.19 %gcc +foo
+`
+
+// Directory of the concrete specs, in the mirror.
+const spackSpecsDirectory = "specs"
+
+// Directory of the configuration scope pointing Spack to the mirror, in the
+// project.
+const spackConfigDirectory = "spack-config"
+
+// Parameters of a spec node which are not variants.
+var spackFlags = []string{"cflags", "cppflags", "cxxflags", "fflags",
+	"ldflags", "ldlibs", "patches"}
+
+// System packages of the compilers Spack builds with, by compiler and
+// package manager.
+var spackCompilerPackages = map[string]map[string][]string{
+	"gcc": {"apt": {"gcc", "g++", "gfortran"}, "dnf": {"gcc", "gcc-c++", "gcc-gfortran"},
+		"apk": {"gcc", "g++", "gfortran"}},
+	"clang": {"apt": {"clang"}, "dnf": {"clang"}, "apk": {"clang"}},
+}
+
+// Registers the spackModule.
+func init() { Register(spackModuleName, func() Module { return &spackModule{} }) }
+
+// spackModule is used to manage the spack scope in the credospell
+// configuration.
+type spackModule struct{}
+
+type spackSpell struct {
+	// Spec is the abstract spec requested, such as samtools@1.19 +foo.
+	Spec    string `yaml:"spec"`
+	Name    string `yaml:"name,omitempty"`
+	Version string `yaml:"version,omitempty"`
+	// Hash is the DAG hash of the concretized spec.
+	Hash         string   `yaml:"hash,omitempty"`
+	Compiler     string   `yaml:"compiler,omitempty"`
+	Variants     []string `yaml:"variants,omitempty"`
+	Architecture string   `yaml:"architecture,omitempty"`
+	// ExternalDependencies are the spells required by the package, the
+	// packages of its compiler.
+	ExternalDependencies Config `yaml:"external_dependencies,omitempty"`
+}
+
+// Function used to check if two spackSpell objects are equal.
+// It takes in an equatable interface as a parameter and returns a boolean
+// value indicating whether the two objects are equal or not.
+// The function first checks if the input parameter t is of type spackSpell.
+//
+// If it is, it proceeds to compare the Spec and the Hash of the two objects.
+// The function returns true if the two objects are equal.
+// Otherwise, it returns false.
+func (s spackSpell) equals(t equatable) bool {
+	o, err := types.To[spackSpell](t)
+	if err != nil {
+		return false
+	}
+	return o.Spec == s.Spec && o.Hash == s.Hash
+}
+
+// pinned returns the spec pinning the recorded version, compiler, variants
+// and architecture of the package.
+func (s spackSpell) pinned() string {
+	if s.Version == "" {
+		return s.Spec
+	}
+	spec := []string{s.Name + "@=" + s.Version}
+	if s.Compiler != "" {
+		spec = append(spec, "%"+s.Compiler)
+	}
+	spec = append(spec, s.Variants...)
+	if s.Architecture != "" {
+		spec = append(spec, "arch="+s.Architecture)
+	}
+	return strings.Join(spec, " ")
+}
+
+// specFile returns the name of the file of the concrete spec, in the mirror.
+func (s spackSpell) specFile() string {
+	return fmt.Sprintf("%s-%s.json", s.Name, s.Hash)
+}
+
+// spackNode is a node of a concrete spec, as printed by spack spec --json.
+type spackNode struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Hash     string `json:"hash"`
+	Compiler *struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"compiler"`
+	Parameters map[string]any `json:"parameters"`
+	Arch       *struct {
+		Platform   string `json:"platform"`
+		PlatformOS string `json:"platform_os"`
+		Target     any    `json:"target"`
+	} `json:"arch"`
+}
+
+// spackVariants returns the variants of the parameters of a node, sorted by
+// name.
+func spackVariants(parameters map[string]any) []string {
+	variants := []string{}
+	for name, value := range parameters {
+		if slices.Contains(spackFlags, name) {
+			continue
+		}
+		switch v := value.(type) {
+		case bool:
+			if v {
+				variants = append(variants, "+"+name)
+			} else {
+				variants = append(variants, "~"+name)
+			}
+		case []any:
+			values := []string{}
+			for _, value := range v {
+				values = append(values, fmt.Sprint(value))
+			}
+			variants = append(variants, name+"="+strings.Join(values, ","))
+		default:
+			variants = append(variants, name+"="+fmt.Sprint(v))
+		}
+	}
+	slices.SortFunc(variants, func(a, b string) int {
+		return strings.Compare(strings.TrimLeft(a, "+~"), strings.TrimLeft(b, "+~"))
+	})
+	return variants
+}
+
+// parseSpackSpec parses the concrete spec printed by spack spec --json into
+// the spell of its root.
+func parseSpackSpec(output []byte) (spackSpell, error) {
+	var document struct {
+		Spec struct {
+			Nodes []spackNode `json:"nodes"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(output, &document); err != nil {
+		return spackSpell{}, fmt.Errorf("parsing the concrete spec: %v", err)
+	}
+	if len(document.Spec.Nodes) == 0 {
+		return spackSpell{}, errors.New("The concrete spec has no nodes.")
+	}
+	root := document.Spec.Nodes[0]
+	spell := spackSpell{
+		Name:     root.Name,
+		Version:  root.Version,
+		Hash:     root.Hash,
+		Variants: spackVariants(root.Parameters),
+	}
+	if root.Compiler != nil {
+		spell.Compiler = root.Compiler.Name + "@=" + root.Compiler.Version
+	}
+	if root.Arch != nil {
+		target := root.Arch.Target
+		if named, ok := target.(map[string]any); ok {
+			target = named["name"]
+		}
+		spell.Architecture = fmt.Sprintf("%s-%s-%v", root.Arch.Platform,
+			root.Arch.PlatformOS, target)
+	}
+	return spell, nil
+}
+
+// spackConfigScope writes the configuration scope declaring the mirror of the
+// project, and returns the arguments selecting it.
+func spackConfigScope(mirror string) ([]string, error) {
+	project, err := project.ProjectPath()
+	if err != nil {
+		return nil, err
+	}
+	scope := path.Join(*project, spackConfigDirectory)
+	if err = os.MkdirAll(scope, 0755); err != nil {
+		return nil, err
+	}
+	mirrors := fmt.Sprintf("# This file is automatically generated by CREDO.\nmirrors:\n  credo: file://%s\n",
+		mirror)
+	err = os.WriteFile(path.Join(scope, "mirrors.yaml"), []byte(mirrors), 0644)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"--config-scope", scope}, nil
+}
+
+// spackMirror returns the directory of the mirror in the project.
+func spackMirror() (string, error) {
+	project, err := project.ProjectPath()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(*project, spackModuleName), nil
+}
+
+// spackConcretize concretizes the spec, returning the concrete spec.
+func spackConcretize(spec string) ([]byte, error) {
+	args := append([]string{"spec", "--json"}, strings.Fields(spec)...)
+	cmd := exec.Command("spack", args...)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("concretizing %s: %v", spec, err)
+	}
+	return output, nil
+}
+
+// BulkSave implements Module.
+func (m *spackModule) BulkSave(config *Config) error {
+	for _, ss := range config.Spack {
+		err := m.Save(ss)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CliConfig implements Module.
+func (m *spackModule) CliConfig(config *Config) *cobra.Command {
+	return &cobra.Command{
+		Args:    m.cobraArgs(),
+		Example: spackModuleExample,
+		Run:     m.cobraRun(config),
+		Short:   spackModuleShort,
+		Use:     spackModuleName,
+		// Variants disabled with a dash, such as -foo, are part of the spec.
+		DisableFlagParsing: true,
+	}
+}
+
+// Function used to validate the arguments passed to the spack command.
+// If no arguments are passed, it returns an error.
+// Otherwise it returns nil.
+//
+// Intended to be used by cobra.
+func (m *spackModule) cobraArgs() func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return fmt.Errorf("%s module requires at least one argument.",
+				spackModuleName)
+		}
+		return nil
+	}
+}
+
+// Function used to run the module from the command line.
+// It serves as an entry point to the bare run of the spackModule.
+//
+// Intended to be used by cobra.
+func (m *spackModule) cobraRun(config *Config) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, args []string) {
+		spell, err := m.bareRun(spackSpell{
+			Spec: strings.Join(args, " "),
+		})
+		if err != nil {
+			logger.Get().Fatal(err)
+		}
+		err = m.Commit(config, spell)
+		if err != nil && err != ErrAlreadyPresent {
+			logger.Get().Fatal(err)
+		}
+	}
+}
+
+// bareRun concretizes the spec, recording the hash, the compiler, the
+// variants and the architecture chosen by Spack. The concrete spec is
+// written into the mirror, so that saving does not concretize again. The
+// system packages of the compiler are recorded as external dependencies.
+func (*spackModule) bareRun(s spackSpell) (spackSpell, error) {
+	if spell := cache.Retrieve(spackModuleName+"bare", s.Spec); spell != nil {
+		newSpell, err := types.To[spackSpell](spell)
+		if err == nil {
+			return *newSpell, nil
+		}
+	}
+	output, err := spackConcretize(s.Spec)
+	if err != nil {
+		return spackSpell{}, err
+	}
+	spell, err := parseSpackSpec(output)
+	if err != nil {
+		return spackSpell{}, err
+	}
+	mirror, err := spackMirror()
+	if err != nil {
+		return spackSpell{}, err
+	}
+	if err = os.MkdirAll(path.Join(mirror, spackSpecsDirectory), 0755); err != nil {
+		return spackSpell{}, err
+	}
+	err = os.WriteFile(path.Join(mirror, spackSpecsDirectory, spell.specFile()),
+		output, 0644)
+	if err != nil {
+		return spackSpell{}, err
+	}
+	spell.Spec = s.Spec
+	spell.ExternalDependencies = s.ExternalDependencies
+	compiler, _, _ := strings.Cut(spell.Compiler, "@")
+	recordSystemPackages(&spell.ExternalDependencies, spackCompilerPackages[compiler])
+	_ = cache.Insert(spackModuleName+"bare", s.Spec, spell)
+	return spell, nil
+}
+
+// Commit implements Module.
+func (*spackModule) Commit(config *Config, result any) error {
+	newEntry, err := types.To[spackSpell](result)
+	if err != nil {
+		return ErrConverting
+	}
+	if Contains(config.Spack, *newEntry) {
+		return ErrAlreadyPresent
+	}
+	config.Spack = append(config.Spack, *newEntry)
+	return nil
+}
+
+// Save implements Module. The sources of all the nodes of the concrete spec
+// recorded by bareRun are stored in the mirror, without concretizing again.
+func (*spackModule) Save(anySpell any) error {
+	spell, err := types.To[spackSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	if cache.Retrieve(spackModuleName+"save", spell.Hash) != nil {
+		return nil
+	}
+	err = DeepSave(&spell.ExternalDependencies)
+	if err != nil {
+		return err
+	}
+	mirror, err := spackMirror()
+	if err != nil {
+		return err
+	}
+	specFile := path.Join(mirror, spackSpecsDirectory, spell.specFile())
+	if _, err := os.Stat(specFile); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("The concrete spec of %s is missing, run `credo spack %s` again.",
+			spell.Spec, spell.pinned())
+	}
+	// Spack reads the concrete spec from a path ending with .json.
+	out, err := exec.Command("spack", "mirror", "create", "--directory", mirror,
+		"--dependencies", specFile).CombinedOutput()
+	logger.Get().Print(string(out))
+	if err != nil {
+		return fmt.Errorf("mirroring %s: %v", spell.Spec, err)
+	}
+	_ = cache.Insert(spackModuleName+"save", spell.Hash, true)
+	return nil
+}
+
+// Apply implements Module. The recorded concrete spec is installed, fetching
+// the sources from the mirror of the project.
+func (*spackModule) Apply(anySpell any) error {
+	spell, err := types.To[spackSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	if cache.Retrieve(spackModuleName+"apply", spell.Hash) != nil {
+		return nil
+	}
+	err = DeepApply(&spell.ExternalDependencies)
+	if err != nil {
+		return err
+	}
+	mirror, err := spackMirror()
+	if err != nil {
+		return err
+	}
+	specFile := path.Join(mirror, spackSpecsDirectory, spell.specFile())
+	if _, err := os.Stat(specFile); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s has not been saved, run `credo save` first.",
+			spell.Spec)
+	}
+	scope, err := spackConfigScope(mirror)
+	if err != nil {
+		return err
+	}
+	args := append(scope, "install", "--file", specFile)
+	out, err := exec.Command("spack", args...).CombinedOutput()
+	logger.Get().Print(string(out))
+	if err != nil {
+		return fmt.Errorf("installing %s: %v", spell.Spec, err)
+	}
+	_ = cache.Insert(spackModuleName+"apply", spell.Hash, true)
+	return nil
+}
+
+// BulkApply implements Module.
+func (m *spackModule) BulkApply(config *Config) error {
+	for _, ss := range config.Spack {
+		err := m.Apply(ss)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseSpackSpec(t *testing.T) {
+	output := []byte(`{"spec": {"_meta": {"version": 4}, "nodes": [
+{"name": "samtools", "version": "1.19", "hash": "abcdef",
+ "arch": {"platform": "linux", "platform_os": "rocky9", "target": {"name": "zen2"}},
+ "compiler": {"name": "gcc", "version": "11.4.1"},
+ "parameters": {"build_system": "autotools", "foo": true, "bar": false,
+  "cflags": [], "patches": ["0123"], "libs": ["shared", "static"]}},
+{"name": "zlib", "version": "1.3", "hash": "012345"}]}}`)
+	want := spackSpell{
+		Name:         "samtools",
+		Version:      "1.19",
+		Hash:         "abcdef",
+		Compiler:     "gcc@=11.4.1",
+		Variants:     []string{"~bar", "build_system=autotools", "+foo", "libs=shared,static"},
+		Architecture: "linux-rocky9-zen2",
+	}
+	got, err := parseSpackSpec(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSpackSpec() = %v, want %v", got, want)
+	}
+	wantPinned := "samtools@=1.19 %gcc@=11.4.1 ~bar build_system=autotools +foo libs=shared,static arch=linux-rocky9-zen2"
+	if pinned := got.pinned(); pinned != wantPinned {
+		t.Errorf("pinned() = %v, want %v", pinned, wantPinned)
+	}
+	if _, err := parseSpackSpec([]byte(`{"spec": {"nodes": []}}`)); err == nil {
+		t.Errorf("parseSpackSpec() accepted a spec without nodes")
+	}
+}
@@ -0,0 +1,374 @@
+package modules
+
+import (
+	"credo/cache"
+	"credo/logger"
+	"credo/project"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/CREDOProject/sharedutils/types"
+	"github.com/spf13/cobra"
+)
+
+const urlModuleName = "url"
+
+const urlModuleShort = "Retrieves a file, such as a release tarball, from an URL."
+
+const urlModuleExample = `
+Install a static binary, executable as it is copied into bin:
+	credo url https://example.org/tool-linux-amd64 --destination bin/tool
+
+Install a release tarball, checking its hash:
+	credo url https://example.org/tool-1.0.tar.gz --sha256 <hash> --strip-components 1
+`
+
+// Directory of the prefix the files are installed into, in the project.
+const urlPrefixDirectory = "prefix"
+
+// Permissions of the files, other than archives, copied into bin.
+const urlExecutableMode = "0755"
+
+// Registers the urlModule.
+func init() { Register(urlModuleName, func() Module { return &urlModule{} }) }
+
+// urlModule is used to manage the url scope in the credospell configuration.
+type urlModule struct{}
+
+type urlSpell struct {
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256,omitempty"`
+	Size   int64  `yaml:"size,omitempty"`
+	// Destination is the path, in the prefix, the archive is extracted or
+	// the file is copied into.
+	Destination string `yaml:"destination,omitempty"`
+	// StripComponents is the number of leading components removed from the
+	// entries of an archive.
+	StripComponents int `yaml:"strip_components,omitempty"`
+	// Mode is the octal permissions, such as 0755, of the destination.
+	// Files other than archives copied into bin are executable by default.
+	Mode string `yaml:"mode,omitempty"`
+	// ExternalDependencies are the spells required by the file, the
+	// decompressor of an archive.
+	ExternalDependencies Config `yaml:"external_dependencies,omitempty"`
+}
+
+// Function used to check if two urlSpell objects are equal.
+// It takes in an equatable interface as a parameter and returns a boolean
+// value indicating whether the two objects are equal or not.
+// The function first checks if the input parameter t is of type urlSpell.
+//
+// If it is, it proceeds to compare the URL, SHA256 and Destination of the
+// two objects.
+// The function returns true if the two objects are equal.
+// Otherwise, it returns false.
+func (u urlSpell) equals(t equatable) bool {
+	o, err := types.To[urlSpell](t)
+	if err != nil {
+		return false
+	}
+	return o.URL == u.URL && strings.EqualFold(o.SHA256, u.SHA256) &&
+		o.Destination == u.Destination
+}
+
+// fileName returns the name of the file the URL points to.
+func (u urlSpell) fileName() string {
+	name := path.Base(u.URL)
+	if parsed, err := url.Parse(u.URL); err == nil {
+		name = path.Base(parsed.Path)
+	}
+	if name == "." || name == "/" {
+		return "download"
+	}
+	return name
+}
+
+// file returns the path of the downloaded file, under the directory of the
+// module. The hash keeps apart the files of the same name.
+func (u urlSpell) file(downloadPath string) string {
+	return path.Join(downloadPath, strings.ToLower(u.SHA256), u.fileName())
+}
+
+// defaultDestination returns the destination of a spell without one: the
+// directory named after an archive, or bin for other files.
+func (u urlSpell) defaultDestination() string {
+	name := u.fileName()
+	if kind := archiveKind(name); kind != "" {
+		return strings.TrimSuffix(name, name[len(name)-len(kind):])
+	}
+	return path.Join("bin", name)
+}
+
+// mode returns the permissions of the destination: the ones of the spell,
+// or executable permissions for a file, other than an archive, copied into
+// bin.
+func (u urlSpell) mode() string {
+	if u.Mode != "" || archiveKind(u.fileName()) != "" {
+		return u.Mode
+	}
+	if strings.HasPrefix(filepath.ToSlash(filepath.Clean(u.Destination)), "bin/") {
+		return urlExecutableMode
+	}
+	return u.Mode
+}
+
+// validate checks the destination and the mode of the spell.
+func (u urlSpell) validate() error {
+	if !filepath.IsLocal(u.Destination) {
+		return fmt.Errorf("%w url destination %s is not a path inside the prefix.",
+			ErrInvalidSpell, u.Destination)
+	}
+	if u.Mode != "" {
+		if _, err := strconv.ParseUint(u.Mode, 8, 32); err != nil {
+			return fmt.Errorf("%w url mode %s is not octal permissions.",
+				ErrInvalidSpell, u.Mode)
+		}
+	}
+	return nil
+}
+
+// BulkSave implements Module.
+func (m *urlModule) BulkSave(config *Config) error {
+	for _, us := range config.URL {
+		err := m.Save(us)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CliConfig implements Module.
+func (m *urlModule) CliConfig(config *Config) *cobra.Command {
+	command := &cobra.Command{
+		Args:    m.cobraArgs(),
+		Example: urlModuleExample,
+		Run:     m.cobraRun(config),
+		Short:   urlModuleShort,
+		Use:     urlModuleName,
+	}
+	command.Flags().String("sha256", "", "Expected SHA-256 hash of the file.")
+	command.Flags().String("destination", "",
+		"Path in the prefix the file is extracted or copied into.")
+	command.Flags().Int("strip-components", 0,
+		"Number of leading components removed from the entries of an archive.")
+	command.Flags().String("chmod", "",
+		"Octal permissions of the destination, 0755 for files copied into bin.")
+	return command
+}
+
+// Function used to validate the arguments passed to the url command.
+// If no arguments are passed, it returns an error.
+// Otherwise it returns nil.
+//
+// Intended to be used by cobra.
+func (m *urlModule) cobraArgs() func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return fmt.Errorf("%s module requires at least one argument.",
+				urlModuleName)
+		}
+		parsed, err := url.Parse(args[0])
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return fmt.Errorf("\"%s\" doesn't look like an http url.", args[0])
+		}
+		return nil
+	}
+}
+
+// Function used to run the module from the command line.
+// It serves as an entry point to the bare run of the urlModule.
+//
+// Intended to be used by cobra.
+func (m *urlModule) cobraRun(config *Config) func(*cobra.Command, []string) {
+	return func(cmd *cobra.Command, args []string) {
+		sha256, _ := cmd.Flags().GetString("sha256")
+		destination, _ := cmd.Flags().GetString("destination")
+		strip, _ := cmd.Flags().GetInt("strip-components")
+		mode, _ := cmd.Flags().GetString("chmod")
+		spell, err := m.bareRun(urlSpell{
+			URL:             args[0],
+			SHA256:          sha256,
+			Destination:     destination,
+			StripComponents: strip,
+			Mode:            mode,
+		})
+		if err != nil {
+			logger.Get().Fatal(err)
+		}
+		err = m.Commit(config, spell)
+		if err != nil && err != ErrAlreadyPresent {
+			logger.Get().Fatal(err)
+		}
+	}
+}
+
+// bareRun downloads the file, checking its hash when one is given, and
+// records its hash and size, and the system packages of the decompressor of
+// an archive.
+func (*urlModule) bareRun(s urlSpell) (urlSpell, error) {
+	if spell := cache.Retrieve(urlModuleName+"bare", s.URL); spell != nil {
+		newSpell, err := types.To[urlSpell](spell)
+		if err == nil {
+			return *newSpell, nil
+		}
+	}
+	if s.Destination == "" {
+		s.Destination = s.defaultDestination()
+	}
+	if err := s.validate(); err != nil {
+		return urlSpell{}, err
+	}
+	project, err := project.ProjectPath()
+	if err != nil {
+		return urlSpell{}, err
+	}
+	downloadPath := path.Join(*project, urlModuleName)
+	if err = os.MkdirAll(downloadPath, 0755); err != nil {
+		return urlSpell{}, err
+	}
+	temporary, err := os.MkdirTemp(downloadPath, ".download")
+	if err != nil {
+		return urlSpell{}, err
+	}
+	defer os.RemoveAll(temporary)
+	downloaded := path.Join(temporary, s.fileName())
+	s.SHA256, s.Size, err = downloadURL(s.URL, downloaded, s.SHA256)
+	if err != nil {
+		return urlSpell{}, err
+	}
+	if err = os.MkdirAll(path.Dir(s.file(downloadPath)), 0755); err != nil {
+		return urlSpell{}, err
+	}
+	if err = os.Rename(downloaded, s.file(downloadPath)); err != nil {
+		return urlSpell{}, err
+	}
+	compression := archiveCompression(archiveKind(s.fileName()))
+	recordSystemPackages(&s.ExternalDependencies, decompressorPackages[compression])
+	_ = cache.Insert(urlModuleName+"bare", s.URL, s)
+	return s, nil
+}
+
+// Commit implements Module.
+func (*urlModule) Commit(config *Config, result any) error {
+	newEntry, err := types.To[urlSpell](result)
+	if err != nil {
+		return ErrConverting
+	}
+	if Contains(config.URL, *newEntry) {
+		return ErrAlreadyPresent
+	}
+	config.URL = append(config.URL, *newEntry)
+	return nil
+}
+
+// Save implements Module. The file is downloaded unless it has already been,
+// and its hash and size are checked.
+func (*urlModule) Save(anySpell any) error {
+	spell, err := types.To[urlSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	if spell.SHA256 == "" {
+		return fmt.Errorf("%w url %s has no sha256.", ErrInvalidSpell, spell.URL)
+	}
+	if cache.Retrieve(urlModuleName+"save", spell.SHA256) != nil {
+		return nil
+	}
+	err = DeepSave(&spell.ExternalDependencies)
+	if err != nil {
+		return err
+	}
+	project, err := project.ProjectPath()
+	if err != nil {
+		return err
+	}
+	file := spell.file(path.Join(*project, urlModuleName))
+	hash, err := sha256File(file)
+	if err != nil || !strings.EqualFold(hash, spell.SHA256) {
+		_, size, err := downloadURL(spell.URL, file, spell.SHA256)
+		if err != nil {
+			return err
+		}
+		if spell.Size != 0 && size != spell.Size {
+			return fmt.Errorf("%s has size %d, expected %d.", spell.URL, size,
+				spell.Size)
+		}
+	}
+	_ = cache.Insert(urlModuleName+"save", spell.SHA256, true)
+	return nil
+}
+
+// Apply implements Module. Archives are extracted into the destination,
+// other files are copied to it.
+func (*urlModule) Apply(anySpell any) error {
+	spell, err := types.To[urlSpell](anySpell)
+	if err != nil {
+		return ErrConverting
+	}
+	if err = spell.validate(); err != nil {
+		return err
+	}
+	err = DeepApply(&spell.ExternalDependencies)
+	if err != nil {
+		return err
+	}
+	project, err := project.ProjectPath()
+	if err != nil {
+		return err
+	}
+	file := spell.file(path.Join(*project, urlModuleName))
+	if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s has not been saved, run `credo save` first.",
+			spell.URL)
+	}
+	destination := path.Join(*project, urlPrefixDirectory, spell.Destination)
+	if err = installURLFile(file, destination, spell.StripComponents,
+		spell.mode()); err != nil {
+		return err
+	}
+	logger.Get().Printf("[url/apply]: %s installed into %s.", spell.URL,
+		destination)
+	return nil
+}
+
+// installURLFile extracts the archive into the destination, or copies the
+// file to it, then sets the permissions of the destination.
+func installURLFile(file string, destination string, strip int, mode string) error {
+	var err error
+	if archiveKind(file) != "" {
+		if err = os.MkdirAll(destination, 0755); err == nil {
+			err = extractArchive(file, destination, strip)
+		}
+	} else {
+		err = copyFile(file, destination, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("installing %s: %v", filepath.Base(file), err)
+	}
+	if mode == "" {
+		return nil
+	}
+	permissions, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(destination, os.FileMode(permissions))
+}
+
+// BulkApply implements Module.
+func (m *urlModule) BulkApply(config *Config) error {
+	for _, us := range config.URL {
+		err := m.Apply(us)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
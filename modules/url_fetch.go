@@ -0,0 +1,451 @@
+package modules
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"credo/logger"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time after which a connection or a download without progress is
+// abandoned.
+const httpStallTimeout = time.Minute
+
+// httpTransport abandons the servers which stop responding.
+var httpTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   httpStallTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	TLSHandshakeTimeout:   httpStallTimeout,
+	ResponseHeaderTimeout: httpStallTimeout,
+	IdleConnTimeout:       90 * time.Second,
+}
+
+// httpClient is the client of the requests whose responses are small, such
+// as metadata. Downloads are not limited in duration, but abandoned when
+// they stall.
+var httpClient = &http.Client{Transport: httpTransport, Timeout: 5 * time.Minute}
+
+// stallReader cancels a download when reading does not progress for
+// httpStallTimeout.
+type stallReader struct {
+	reader io.Reader
+	timer  *time.Timer
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.timer.Reset(httpStallTimeout)
+	}
+	return n, err
+}
+
+// downloadURL streams the content of the URL into the file, through a
+// partial file renamed once complete, and returns its hash and size. A
+// partial file left by an interrupted download is resumed when the server
+// supports ranges. When an hash is expected, a content with another hash is
+// discarded.
+func downloadURL(url string, file string, expected string) (string, int64, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return "", 0, err
+	}
+	partial := file + ".part"
+	hash := sha256.New()
+	// The content already downloaded is hashed again, as the hash of an
+	// interrupted download is not kept.
+	offset, err := hashFile(partial, hash)
+	if err != nil {
+		offset = 0
+		hash.Reset()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("downloading %s: %v", url, err)
+	}
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	response, err := (&http.Client{Transport: httpTransport}).Do(request)
+	if err != nil {
+		return "", 0, fmt.Errorf("downloading %s: %v", url, err)
+	}
+	defer response.Body.Close()
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case offset > 0 && response.StatusCode == http.StatusPartialContent:
+		flags |= os.O_APPEND
+		logger.Get().Printf("[download]: resuming %s at %d bytes.", url, offset)
+	case offset > 0 && response.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is complete when it has the size announced by
+		// the server, otherwise it is not a prefix of the content.
+		response.Body.Close()
+		if contentRangeSize(response.Header.Get("Content-Range")) == offset {
+			return finishDownload(url, partial, file, hash, offset, expected)
+		}
+		logger.Get().Printf("[download]: restarting %s, the partial file does not match.", url)
+		if err = os.Remove(partial); err != nil {
+			return "", 0, err
+		}
+		return downloadURL(url, file, expected)
+	case response.StatusCode == http.StatusOK:
+		flags |= os.O_TRUNC
+		offset = 0
+		hash.Reset()
+	default:
+		return "", 0, fmt.Errorf("downloading %s: %s", url, response.Status)
+	}
+	f, err := os.OpenFile(partial, flags, 0644)
+	if err != nil {
+		return "", 0, err
+	}
+	body := &stallReader{response.Body, time.AfterFunc(httpStallTimeout, cancel)}
+	size, err := io.Copy(io.MultiWriter(f, hash), body)
+	body.timer.Stop()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("downloading %s, run again to resume: %v", url, err)
+	}
+	return finishDownload(url, partial, file, hash, offset+size, expected)
+}
+
+// contentRangeSize returns the size of the content from the Content-Range
+// header of an unsatisfiable range, bytes */<size>, or -1 when it is unknown.
+func contentRangeSize(header string) int64 {
+	value, ok := strings.CutPrefix(header, "bytes */")
+	if !ok {
+		return -1
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// finishDownload checks the hash of a complete partial file and renames it
+// to the file.
+func finishDownload(url string, partial string, file string, hash hash.Hash,
+	size int64, expected string) (string, int64, error) {
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if expected != "" && !strings.EqualFold(sum, expected) {
+		os.Remove(partial)
+		return "", 0, fmt.Errorf("%s has sha256 %s, expected %s.", url, sum, expected)
+	}
+	return sum, size, os.Rename(partial, file)
+}
+
+// hashFile writes the content of the file into the hash, returning its size.
+func hashFile(file string, hash hash.Hash) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(hash, f)
+}
+
+// archiveKind returns the kind of archive of the file from its name, or an
+// empty string when it is not an archive.
+func archiveKind(file string) string {
+	name := strings.ToLower(filepath.Base(file))
+	for _, kind := range []string{".tar.gz", ".tgz", ".tar.bz2", ".tbz2",
+		".tar.xz", ".txz", ".tar.zst", ".tar", ".zip"} {
+		if strings.HasSuffix(name, kind) {
+			return kind
+		}
+	}
+	return ""
+}
+
+// archiveCompression returns the compression of an archive kind, empty for
+// the uncompressed ones.
+func archiveCompression(kind string) string {
+	switch kind {
+	case ".tar.gz", ".tgz":
+		return "gzip"
+	case ".tar.bz2", ".tbz2":
+		return "bzip2"
+	case ".tar.xz", ".txz":
+		return "xz"
+	case ".tar.zst":
+		return "zstd"
+	}
+	return ""
+}
+
+// stripComponents removes the first strip components of the name of an
+// entry of an archive. It returns an empty name when nothing is left of it,
+// and fails when the name escapes the destination.
+func stripComponents(name string, strip int) (string, error) {
+	parts := strings.Split(strings.Trim(filepath.ToSlash(name), "/"), "/")
+	if len(parts) <= strip {
+		return "", nil
+	}
+	stripped := filepath.Clean(filepath.Join(parts[strip:]...))
+	if stripped == "." {
+		return "", nil
+	}
+	if !filepath.IsLocal(stripped) || filepath.IsAbs(name) {
+		return "", fmt.Errorf("%s escapes the destination", name)
+	}
+	return stripped, nil
+}
+
+// extractedPath returns the path of an entry of an archive in the
+// destination. It fails when a parent directory of the entry is a symbolic
+// link, through which the entry could escape the destination.
+func extractedPath(destination string, name string) (string, error) {
+	parent := destination
+	parts := strings.Split(name, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s is below the symbolic link %s", name,
+				filepath.Base(parent))
+		}
+	}
+	return filepath.Join(destination, name), nil
+}
+
+// extractArchive extracts the archive into the destination, removing the
+// first strip components of every entry.
+func extractArchive(file string, destination string, strip int) error {
+	kind := archiveKind(file)
+	if kind == ".zip" {
+		return extractZip(file, destination, strip)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader, wait, err := decompressedReader(f, archiveCompression(kind))
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", filepath.Base(file), err)
+	}
+	err = extractTar(tar.NewReader(reader), destination, strip)
+	if waitErr := wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("reading %s: %v", filepath.Base(file), waitErr)
+	}
+	return err
+}
+
+// System packages of the decompressors run by decompressedReader, by
+// compression and package manager.
+var decompressorPackages = map[string]map[string][]string{
+	"xz":   {"apt": {"xz-utils"}, "dnf": {"xz"}, "apk": {"xz"}},
+	"zstd": {"apt": {"zstd"}, "dnf": {"zstd"}, "apk": {"zstd"}},
+}
+
+// decompressedReader returns the decompressed content of the file, for the
+// compressions gzip, bzip2, xz and zstd, or its content when the compression
+// is empty. The returned function reads the rest of the content, so that a
+// truncated or corrupted file is reported, and releases the decompressor.
+func decompressedReader(f *os.File, compression string) (io.Reader, func() error, error) {
+	drain := func(reader io.Reader) error {
+		_, err := io.Copy(io.Discard, reader)
+		return err
+	}
+	switch compression {
+	case "":
+		return f, func() error { return nil }, nil
+	case "gzip":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, func() error {
+			err := drain(gz)
+			if closeErr := gz.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}, nil
+	case "bzip2":
+		reader := bzip2.NewReader(f)
+		return reader, func() error { return drain(reader) }, nil
+	case "xz", "zstd":
+		var stderr bytes.Buffer
+		cmd := exec.Command(compression, "--decompress", "--stdout")
+		cmd.Stdin = f
+		cmd.Stderr = &stderr
+		output, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = cmd.Start(); err != nil {
+			return nil, nil, err
+		}
+		return output, func() error {
+			drain(output)
+			if err := cmd.Wait(); err != nil {
+				return fmt.Errorf("%s: %v %s", compression, err,
+					strings.TrimSpace(stderr.String()))
+			}
+			return nil
+		}, nil
+	}
+	return nil, nil, fmt.Errorf("Unknown compression: %s", compression)
+}
+
+// extractTar extracts the entries of the tar archive into the destination.
+// Entries and links escaping the destination are rejected.
+func extractTar(reader *tar.Reader, destination string, strip int) error {
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name, err := stripComponents(header.Name, strip)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		target, err := extractedPath(destination, name)
+		if err != nil {
+			return err
+		}
+		mode := header.FileInfo().Mode().Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeExtracted(target, reader, mode)
+		case tar.TypeSymlink:
+			// The link is resolved from its directory.
+			resolved := filepath.Join(filepath.Dir(name), header.Linkname)
+			if filepath.IsAbs(header.Linkname) || !filepath.IsLocal(resolved) {
+				return fmt.Errorf("%s links outside of the destination to %s",
+					header.Name, header.Linkname)
+			}
+			err = replaceWithSymlink(header.Linkname, target)
+		case tar.TypeLink:
+			linked, linkErr := stripComponents(header.Linkname, strip)
+			if linkErr != nil || linked == "" {
+				return fmt.Errorf("%s links outside of the destination to %s",
+					header.Name, header.Linkname)
+			}
+			source, linkErr := extractedPath(destination, linked)
+			if linkErr != nil {
+				return linkErr
+			}
+			_ = os.Remove(target)
+			err = os.Link(source, target)
+		}
+		if err != nil {
+			return fmt.Errorf("extracting %s: %v", header.Name, err)
+		}
+	}
+}
+
+// extractZip extracts the entries of the zip archive into the destination.
+// Entries escaping the destination are rejected.
+func extractZip(file string, destination string, strip int) error {
+	archive, err := zip.OpenReader(file)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", filepath.Base(file), err)
+	}
+	defer archive.Close()
+	for _, entry := range archive.File {
+		name, err := stripComponents(entry.Name, strip)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		target, err := extractedPath(destination, name)
+		if err != nil {
+			return err
+		}
+		if entry.FileInfo().IsDir() {
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		content, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("extracting %s: %v", entry.Name, err)
+		}
+		err = writeExtracted(target, content, entry.Mode().Perm())
+		content.Close()
+		if err != nil {
+			return fmt.Errorf("extracting %s: %v", entry.Name, err)
+		}
+	}
+	return nil
+}
+
+// writeExtracted writes an extracted file, replacing any previous one. The
+// file is created anew, so that a symbolic link is never written through.
+func writeExtracted(target string, content io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	_ = os.Remove(target)
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replaceWithSymlink creates the symbolic link, replacing any previous file.
+func replaceWithSymlink(link string, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	_ = os.Remove(target)
+	return os.Symlink(link, target)
+}
+
+// copyFile copies the file to the target, replacing any previous file.
+func copyFile(file string, target string, mode os.FileMode) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeExtracted(target, f, mode)
+}
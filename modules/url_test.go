@@ -0,0 +1,222 @@
+package modules
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// urlTestArchive returns a gzipped tarball of the files, by name.
+func urlTestArchive(t *testing.T, files map[string]string) []byte {
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	writer := tar.NewWriter(gz)
+	for name, content := range files {
+		err := writer.WriteHeader(&tar.Header{Name: name, Mode: 0755,
+			Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = writer.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func Test_urlDownloadAndInstall(t *testing.T) {
+	archive := urlTestArchive(t, map[string]string{
+		"tool-1.0/bin/tool": "#!/bin/sh\n",
+		"tool-1.0/README":   "readme\n",
+	})
+	binary := []byte("\x7fELF")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tool-1.0.tar.gz":
+			w.Write(archive)
+		case "/tool":
+			w.Write(binary)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	directory := t.TempDir()
+
+	sum := sha256.Sum256(archive)
+	expected := hex.EncodeToString(sum[:])
+	file := filepath.Join(directory, "store", "tool-1.0.tar.gz")
+	hash, size, err := downloadURL(server.URL+"/tool-1.0.tar.gz", file, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != expected || size != int64(len(archive)) {
+		t.Errorf("downloadURL() = %v, %v, want %v, %v", hash, size, expected, len(archive))
+	}
+	_, _, err = downloadURL(server.URL+"/tool", filepath.Join(directory, "store", "tool"), expected)
+	if err == nil {
+		t.Errorf("downloadURL() accepted a file with another hash")
+	}
+	if _, err := os.Stat(filepath.Join(directory, "store", "tool")); err == nil {
+		t.Errorf("downloadURL() kept a file with another hash")
+	}
+	if _, _, err = downloadURL(server.URL+"/missing", filepath.Join(directory, "missing"), ""); err == nil {
+		t.Errorf("downloadURL() accepted a missing file")
+	}
+
+	destination := filepath.Join(directory, "prefix", "tool")
+	if err = installURLFile(file, destination, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bin/tool", "README"} {
+		if _, err := os.Stat(filepath.Join(destination, name)); err != nil {
+			t.Errorf("installURLFile() did not extract %s: %v", name, err)
+		}
+	}
+
+	copied := filepath.Join(directory, "store", "copied")
+	if _, _, err = downloadURL(server.URL+"/tool", copied, ""); err != nil {
+		t.Fatal(err)
+	}
+	destination = filepath.Join(directory, "prefix", "bin", "tool")
+	if err = installURLFile(copied, destination, 0, "0755"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("installURLFile() mode = %v, want 0755", info.Mode().Perm())
+	}
+}
+
+func Test_extractTarEscaping(t *testing.T) {
+	file := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Mode: 0644, Size: 1, Typeflag: tar.TypeReg}
+	}
+	symlink := func(name string, link string) *tar.Header {
+		return &tar.Header{Name: name, Linkname: link, Typeflag: tar.TypeSymlink}
+	}
+	tests := map[string][]*tar.Header{
+		"parent name":        {file("../evil")},
+		"nested parent name": {file("a/../../evil")},
+		"absolute name":      {file("/evil")},
+		"absolute symlink":   {symlink("link", "/"), file("link/evil")},
+		"relative symlink":   {symlink("link", "../.."), file("link/evil")},
+		"symlink chain":      {symlink("d/x", ".."), symlink("d/l", "x/.."), file("d/l/evil")},
+		"inner symlink":      {symlink("d/x", "."), file("d/x/evil")},
+		"hard link":          {{Name: "link", Linkname: "../../evil", Typeflag: tar.TypeLink}},
+		"existing symlink":   {file("outside/evil")},
+	}
+	for name, headers := range tests {
+		directory := t.TempDir()
+		destination := filepath.Join(directory, "prefix")
+		if err := os.MkdirAll(destination, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(directory, filepath.Join(destination, "outside")); err != nil {
+			t.Fatal(err)
+		}
+		var buffer bytes.Buffer
+		writer := tar.NewWriter(&buffer)
+		for _, header := range headers {
+			if err := writer.WriteHeader(header); err != nil {
+				t.Fatal(err)
+			}
+			writer.Write(bytes.Repeat([]byte("x"), int(header.Size)))
+		}
+		writer.Close()
+		err := extractTar(tar.NewReader(&buffer), destination, 0)
+		if err == nil {
+			t.Errorf("extractTar() accepted the %s archive", name)
+		}
+		if _, err := os.Lstat(filepath.Join(directory, "evil")); err == nil {
+			t.Errorf("extractTar() wrote outside of the destination with the %s archive", name)
+		}
+	}
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+	for _, header := range []*tar.Header{file("bin/tool"), symlink("bin/alias", "tool"),
+		symlink("lib/tool", "../bin/tool"),
+		{Name: "bin/copy", Linkname: "bin/tool", Typeflag: tar.TypeLink}} {
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		writer.Write(bytes.Repeat([]byte("x"), int(header.Size)))
+	}
+	writer.Close()
+	destination := t.TempDir()
+	if err := extractTar(tar.NewReader(&buffer), destination, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bin/alias", "lib/tool", "bin/copy"} {
+		if _, err := os.Stat(filepath.Join(destination, name)); err != nil {
+			t.Errorf("extractTar() did not extract %s: %v", name, err)
+		}
+	}
+}
+
+func Test_extractArchiveCorrupted(t *testing.T) {
+	archive := urlTestArchive(t, map[string]string{"bin/tool": "#!/bin/sh\n"})
+	// Corrupts the checksum of the gzip trailer, which follows the end of
+	// the tarball.
+	archive[len(archive)-8] ^= 0xff
+	file := filepath.Join(t.TempDir(), "tool.tar.gz")
+	if err := os.WriteFile(file, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractArchive(file, t.TempDir(), 0); err == nil {
+		t.Error("extractArchive() accepted a corrupted archive")
+	}
+}
+
+func Test_urlSpellDestination(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.org/releases/tool-1.0.tar.gz?raw=1", "tool-1.0"},
+		{"https://example.org/tool-linux-amd64", "bin/tool-linux-amd64"},
+		{"https://example.org/tool.zip", "tool"},
+	}
+	for _, tt := range tests {
+		if got := (urlSpell{URL: tt.url}).defaultDestination(); got != tt.want {
+			t.Errorf("defaultDestination(%s) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+	modes := []struct {
+		spell urlSpell
+		want  string
+	}{
+		{urlSpell{URL: "https://example.org/tool-linux-amd64", Destination: "bin/tool"}, "0755"},
+		{urlSpell{URL: "https://example.org/tool", Destination: "bin/tool", Mode: "0700"}, "0700"},
+		{urlSpell{URL: "https://example.org/data.json", Destination: "share/data.json"}, ""},
+		{urlSpell{URL: "https://example.org/tool.tar.gz", Destination: "bin/tool"}, ""},
+	}
+	for _, tt := range modes {
+		if got := tt.spell.mode(); got != tt.want {
+			t.Errorf("mode(%s, %s) = %v, want %v", tt.spell.URL,
+				tt.spell.Destination, got, tt.want)
+		}
+	}
+	if err := (urlSpell{Destination: "../outside"}).validate(); err == nil {
+		t.Errorf("validate() accepted a destination outside of the prefix")
+	}
+	if err := (urlSpell{Destination: "bin/tool", Mode: "0799"}).validate(); err == nil {
+		t.Errorf("validate() accepted a mode which is not octal")
+	}
+}